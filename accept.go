@@ -4,18 +4,97 @@
 package wrphttp
 
 import (
-	"errors"
+	"fmt"
 	"mime"
 	"net/http"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
 )
 
+// defaultPreferred is the order media types are offered in when the client
+// leaves the choice up to the server.  Fast, scalable results are preferred.
+var defaultPreferred = []mediaType{
+	mtMsgpackL,
+	mtMsgpack,
+	mtJSONL,
+	mtJSON,
+	mtOctetStream,
+	mtOctetStreamXWebpa,
+	mtOctetStreamXXmidt,
+	mtOctetStreamXMidt,
+	mtOctetStreamXmidt,
+}
+
+// NegotiateOption is a functional option for configuring media type
+// negotiation.
+type NegotiateOption interface {
+	apply(*negotiator) error
+}
+
+type negotiateOptionFunc func(*negotiator) error
+
+func (f negotiateOptionFunc) apply(n *negotiator) error {
+	return f(n)
+}
+
+// WithPreferredMediaTypes sets the media types the server is willing to
+// produce, most preferred first.  Only these media types are considered when
+// negotiating, and the first one acceptable to the client is used when the
+// client sends a wildcard such as "*/*" or "application/*".  Each value must be
+// one of the values returned by AllMediaTypes().
+//
+// The default order is MsgpackL, Msgpack, JSONL, JSON and then the
+// octet-stream forms.
+func WithPreferredMediaTypes(mediaTypes ...string) NegotiateOption {
+	return negotiateOptionFunc(func(n *negotiator) error {
+		list := make([]mediaType, 0, len(mediaTypes))
+		for _, s := range mediaTypes {
+			mt, err := toMediaTypeFromMime(s)
+			if err != nil {
+				return err
+			}
+			list = append(list, mt)
+		}
+		n.preferred = list
+		return nil
+	})
+}
+
+type negotiator struct {
+	preferred []mediaType
+}
+
+func newNegotiator(opts ...NegotiateOption) (*negotiator, error) {
+	n := negotiator{
+		preferred: defaultPreferred,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&n); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &n, nil
+}
+
 // NegotiateMediaType examines the headers of the request and returns the
 // media type and style the request wants in response.
-func NegotiateMediaType(r *http.Request) (string, error) {
-	mt, err := negotiatedMediaType(r)
+//
+// The Accept header is handled as described by RFC 9110, Section 12.5.1.  The
+// most specific range matching a media type determines its quality, a quality
+// of 0 means the media type is not acceptable and malformed entries are
+// ignored.  The media type with the highest quality is chosen, with ties going
+// to the more specific range, then the range listed first by the client and
+// finally the server's preference order.  If there is no Accept header, the
+// media type of the request is used if the server can produce it, and the
+// server's most preferred media type is used otherwise or if the request has
+// no Content-Type.
+func NegotiateMediaType(r *http.Request, opts ...NegotiateOption) (string, error) {
+	mt, err := negotiatedMediaType(r, opts...)
 	if err != nil {
 		return "", err
 	}
@@ -23,8 +102,13 @@ func NegotiateMediaType(r *http.Request) (string, error) {
 	return mt.String(), nil
 }
 
-func negotiatedMediaType(r *http.Request) (mediaType, error) {
-	mt, err := examineRequest(r)
+func negotiatedMediaType(r *http.Request, opts ...NegotiateOption) (mediaType, error) {
+	n, err := newNegotiator(opts...)
+	if err != nil {
+		return mtUnknown, err
+	}
+
+	mt, err := n.examineRequest(r)
 	if err != nil || mt == mtUnknown {
 		return mtUnknown, err
 	}
@@ -32,15 +116,60 @@ func negotiatedMediaType(r *http.Request) (mediaType, error) {
 	return mt, nil
 }
 
-// acceptType holds one parsed Accept media type and its parameters
-type acceptType struct {
-	Value  string
-	Params map[string]string
-	Q      float64
+// acceptRange holds one parsed Accept media range.
+type acceptRange struct {
+	typ     string
+	subtype string
+	style   string
+	q       float64
+	index   int
+}
+
+// Specificity levels of a media range, from least to most specific.
+const (
+	noMatch = iota - 1
+	matchAny
+	matchSubtypeWildcard
+	matchType
+	matchTypeAndParams
+)
+
+// matches returns how specifically the range matches the media type, or
+// noMatch.
+func (a acceptRange) matches(mt mediaType) int {
+	full, params, err := mime.ParseMediaType(mt.String())
+	if err != nil {
+		return noMatch
+	}
+	typ, subtype, _ := strings.Cut(full, "/")
+
+	switch {
+	case a.typ == "*" && a.subtype == "*":
+		return matchAny
+	case a.typ != typ:
+		return noMatch
+	case a.subtype == "*":
+		return matchSubtypeWildcard
+	case a.subtype != subtype:
+		return noMatch
+	case a.style == "":
+		return matchType
+	case a.style == params["style"]:
+		return matchTypeAndParams
+	}
+
+	return noMatch
 }
 
+// examineContentType returns the media type of the request.  A multipart
+// request has no single media type, so mtUnknown is returned for it.
 func examineContentType(r *http.Request) (mediaType, error) {
-	mt, err := toMediaTypeFromMime(r.Header.Get("Content-Type"))
+	ct := r.Header.Get("Content-Type")
+	if full, _, err := mime.ParseMediaType(ct); err == nil && strings.HasPrefix(full, "multipart/") {
+		return mtUnknown, nil
+	}
+
+	mt, err := toMediaTypeFromMime(ct)
 	if err != nil {
 		return mtUnknown, err
 	}
@@ -63,65 +192,195 @@ func examineContentType(r *http.Request) (mediaType, error) {
 	return toMediaType(mt.String(), style)
 }
 
-// examineRequest parses Accept and picks best + returns parameters
-func examineRequest(r *http.Request) (mediaType, error) {
-	header := r.Header.Get("Accept")
-	if header == "" {
-		// No Accept header, return in the form the request was made.
-		return examineContentType(r)
+// parseAccept parses the Accept header values, skipping malformed entries.
+func parseAccept(values []string) []acceptRange {
+	var ranges []acceptRange
+	for _, value := range values {
+		for part := range strings.SplitSeq(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+
+			full, params, err := mime.ParseMediaType(part)
+			if err != nil {
+				continue
+			}
+			typ, subtype, ok := strings.Cut(full, "/")
+			if !ok || typ == "" || subtype == "" || (typ == "*" && subtype != "*") {
+				continue
+			}
+
+			q := 1.0
+			if qstr, ok := params["q"]; ok {
+				qf, err := strconv.ParseFloat(qstr, 64)
+				if err != nil || qf < 0 || qf > 1 {
+					continue
+				}
+				q = qf
+			}
+
+			ranges = append(ranges, acceptRange{
+				typ:     typ,
+				subtype: subtype,
+				style:   strings.ToLower(params["style"]),
+				q:       q,
+				index:   len(ranges),
+			})
+		}
 	}
 
-	// Parse client Accept header
-	parts := strings.Split(header, ",")
-	clientAccepted := make([]acceptType, 0, len(parts))
+	return ranges
+}
 
-	for _, part := range parts {
-		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
-		if err != nil {
-			return "", err
+// examineRequest parses Accept and picks the best media type the server is
+// willing to produce.
+func (n *negotiator) examineRequest(r *http.Request) (mediaType, error) {
+	values := r.Header.Values("Accept")
+	if len(values) == 0 || strings.TrimSpace(strings.Join(values, "")) == "" {
+		// No Accept header, return in the form the request was made.  A
+		// request without a Content-Type, such as a plain GET, gets the most
+		// preferred media type.
+		if r.Header.Get("Content-Type") != "" {
+			mt, err := examineContentType(r)
+			if err != nil {
+				return mtUnknown, err
+			}
+			if slices.Contains(n.preferred, mt) {
+				return mt, nil
+			}
+		}
+		if len(n.preferred) == 0 {
+			return mtUnknown, ErrNotAcceptable
 		}
+		return n.preferred[0], nil
+	}
 
-		q := 1.0
-		if qstr, ok := params["q"]; ok {
-			if qf, err := strconv.ParseFloat(qstr, 64); err == nil {
-				q = qf
-				delete(params, "q") // Remove q so only real params are left
+	ranges := parseAccept(values)
+	if len(ranges) == 0 {
+		return mtUnknown, fmt.Errorf("%w: invalid Accept header: %q", ErrNotAcceptable, strings.Join(values, ", "))
+	}
+
+	type candidate struct {
+		mt    mediaType
+		q     float64
+		spec  int
+		index int
+	}
+
+	var best *candidate
+	for _, mt := range n.preferred {
+		// The most specific matching range determines the quality.
+		var match *acceptRange
+		spec := noMatch
+		for i := range ranges {
+			if s := ranges[i].matches(mt); s > spec {
+				spec = s
+				match = &ranges[i]
 			}
 		}
+		if match == nil || match.q == 0 {
+			continue
+		}
 
-		clientAccepted = append(clientAccepted, acceptType{
-			Value:  mediaType,
-			Params: params,
-			Q:      q,
-		})
+		c := candidate{mt: mt, q: match.q, spec: spec, index: match.index}
+		switch {
+		case best == nil,
+			c.q > best.q,
+			c.q == best.q && c.spec > best.spec,
+			c.q == best.q && c.spec == best.spec && c.index < best.index:
+			best = &c
+		}
 	}
 
-	// Sort by descending q
-	sort.SliceStable(clientAccepted, func(i, j int) bool {
-		return clientAccepted[i].Q > clientAccepted[j].Q
-	})
+	if best == nil {
+		return mtUnknown, ErrNotAcceptable
+	}
+
+	return best.mt, nil
+}
+
+// NegotiateEncoding examines the Accept-Encoding header of the request and
+// returns the content coding to use in response.  The header is handled as
+// described by RFC 9110, Section 12.5.3.  Codings with a quality of 0 are not
+// acceptable, "*" matches any coding not listed explicitly and identity is
+// acceptable unless it is excluded by "identity;q=0" or "*;q=0", though any
+// acceptable compression is preferred over an implicit identity.  If there is
+// no Accept-Encoding header, identity is used.
+//
+// The registered content codings are offered in the order returned by
+// ContentCodings().  The optional offered list restricts and orders the
+// codings that may be chosen; identity is always available unless the client
+// excludes it.
+func NegotiateEncoding(r *http.Request, offered ...string) (string, error) {
+	values := r.Header.Values("Accept-Encoding")
+	if len(values) == 0 {
+		return identityCoding, nil
+	}
 
-	hasWildcardAll := false
+	qs := make(map[string]float64)
+	for _, value := range values {
+		for part := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if alias, ok := codingAliases[coding]; ok {
+				coding = alias
+			}
+
+			q := 1.0
+			if params != "" {
+				name, qstr, ok := strings.Cut(strings.TrimSpace(params), "=")
+				if !ok || !strings.EqualFold(strings.TrimSpace(name), "q") {
+					continue
+				}
+				qf, err := strconv.ParseFloat(strings.TrimSpace(qstr), 64)
+				if err != nil || qf < 0 || qf > 1 {
+					continue
+				}
+				q = qf
+			}
 
-	for _, ct := range clientAccepted {
-		if ct.Value == "*/*" || ct.Value == "application/*" {
-			hasWildcardAll = true
+			if _, found := qs[coding]; !found {
+				qs[coding] = q
+			}
+		}
+	}
+
+	if len(offered) == 0 {
+		offered = ContentCodings()
+	} else {
+		offered = append(slices.Clone(offered), identityCoding)
+	}
+
+	best, bestQ := "", 0.0
+	for _, coding := range offered {
+		coding = strings.ToLower(coding)
+		if _, ok := LookupContentCoding(coding); !ok {
 			continue
 		}
 
-		// Exact match
-		style := strings.ToLower(ct.Params["style"])
-		mt, _ := toMediaType(ct.Value, style)
-		if mt != mtUnknown {
-			return mt, nil
+		q, ok := qs[coding]
+		if !ok {
+			q, ok = qs["*"]
+		}
+		if !ok {
+			if coding != identityCoding {
+				continue
+			}
+			// Identity is implicitly acceptable, but only as a last resort.
+			q = 0.001
+		}
+		if q > bestQ {
+			best, bestQ = coding, q
 		}
 	}
 
-	// Wildcard */* fallback
-	if hasWildcardAll {
-		// Prefer fast, scalable results when it's up to the server
-		return mtMsgpackL, nil
+	if best == "" {
+		return "", fmt.Errorf("%w: %q", ErrNotAcceptableEncoding, strings.Join(values, ", "))
 	}
 
-	return mtUnknown, errors.New("no acceptable content type found")
+	return best, nil
 }
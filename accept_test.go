@@ -18,6 +18,7 @@ func TestNegotiatedMediaType(t *testing.T) {
 		headers     http.Header
 		want        string
 		expectError bool
+		expectIs    error
 	}{
 		{
 			name:   "Exact match JSON",
@@ -92,6 +93,12 @@ func TestNegotiatedMediaType(t *testing.T) {
 				"X-Webpa-Device-Name": []string{"ignored"},
 			},
 		},
+		{
+			name:   "No Accept header and a multipart request uses the server preference",
+			accept: "",
+			ct:     "multipart/mixed; boundary=abc",
+			want:   MEDIA_TYPE_MSGPACKL,
+		},
 		{
 			name:   "Accept header with style parameter",
 			accept: "application/octet-stream; style=x-xmidt",
@@ -99,29 +106,38 @@ func TestNegotiatedMediaType(t *testing.T) {
 			want:   MEDIA_TYPE_OCTET_STREAM_X_XMIDT_STYLE,
 		},
 
+		{
+			name: "No Accept or Content-Type header picks MsgpackL",
+			want: MEDIA_TYPE_MSGPACKL,
+		},
+
 		// Error cases
 
 		{
 			name:        "Unsupported type returns error",
 			accept:      "image/jpeg",
 			expectError: true,
+			expectIs:    ErrNotAcceptable,
 		},
 		{
 			name:        "No Accept header falls back to content type, is invalid",
 			accept:      "",
 			ct:          "/json",
 			expectError: true,
+			expectIs:    ErrInvalidContentType,
 		},
 		{
 			name:        "Invalid Accept header",
 			accept:      "/json",
 			expectError: true,
+			expectIs:    ErrNotAcceptable,
 		},
 		{
 			name:        "No Accept header falls back to content type, is invalid",
 			accept:      "",
 			ct:          "image/jpeg",
 			expectError: true,
+			expectIs:    ErrUnsupportedMediaType,
 		},
 	}
 
@@ -139,7 +155,7 @@ func TestNegotiatedMediaType(t *testing.T) {
 			mt, err := NegotiateMediaType(req)
 
 			if tt.expectError {
-				assert.Error(t, err)
+				assert.ErrorIs(t, err, tt.expectIs)
 				assert.Empty(t, mt)
 				return
 			}
@@ -149,3 +165,170 @@ func TestNegotiatedMediaType(t *testing.T) {
 		})
 	}
 }
+
+func TestNegotiateMediaTypeRFC9110(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   []string
+		ct       string
+		opts     []NegotiateOption
+		want     string
+		expectIs error
+	}{
+		{
+			name:   "q=0 excludes a type matched by a wildcard",
+			accept: []string{"*/*, application/msgpackl;q=0"},
+			want:   MEDIA_TYPE_MSGPACK,
+		}, {
+			name:     "q=0 on the only range is not acceptable",
+			accept:   []string{"application/json;q=0"},
+			expectIs: ErrNotAcceptable,
+		}, {
+			name:   "specific type beats wildcard with equal q",
+			accept: []string{"*/*, application/json"},
+			want:   MEDIA_TYPE_JSON,
+		}, {
+			name:   "most specific range determines the quality",
+			accept: []string{"application/*;q=0.9, application/msgpackl;q=0.1"},
+			want:   MEDIA_TYPE_MSGPACK,
+		}, {
+			name:   "style parameter is more specific than the bare type",
+			accept: []string{"application/octet-stream;q=0.1, application/octet-stream;style=xmidt"},
+			want:   MEDIA_TYPE_OCTET_STREAM_XMIDT_STYLE,
+		}, {
+			name:   "malformed entries are skipped",
+			accept: []string{"/json, application/json;q=abc, application/jsonl"},
+			want:   MEDIA_TYPE_JSONL,
+		}, {
+			name:   "multiple Accept headers are combined",
+			accept: []string{"application/json;q=0.5", "application/jsonl"},
+			want:   MEDIA_TYPE_JSONL,
+		}, {
+			name:   "client order breaks ties",
+			accept: []string{"application/json, application/msgpack"},
+			want:   MEDIA_TYPE_JSON,
+		}, {
+			name:   "server preference for */*",
+			accept: []string{"*/*"},
+			opts:   []NegotiateOption{WithPreferredMediaTypes(MEDIA_TYPE_JSON, MEDIA_TYPE_MSGPACK)},
+			want:   MEDIA_TYPE_JSON,
+		}, {
+			name:   "server preference for application/*",
+			accept: []string{"application/*"},
+			opts:   []NegotiateOption{WithPreferredMediaTypes(MEDIA_TYPE_JSONL)},
+			want:   MEDIA_TYPE_JSONL,
+		}, {
+			name:     "types the server does not offer are not acceptable",
+			accept:   []string{"application/msgpack"},
+			opts:     []NegotiateOption{WithPreferredMediaTypes(MEDIA_TYPE_JSON)},
+			expectIs: ErrNotAcceptable,
+		}, {
+			name: "no Accept header uses the server preference when the request type is not offered",
+			ct:   MEDIA_TYPE_MSGPACK,
+			opts: []NegotiateOption{WithPreferredMediaTypes(MEDIA_TYPE_JSON)},
+			want: MEDIA_TYPE_JSON,
+		}, {
+			name:     "invalid preferred media type",
+			accept:   []string{"*/*"},
+			opts:     []NegotiateOption{WithPreferredMediaTypes("image/png")},
+			expectIs: ErrUnsupportedMediaType,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			for _, v := range tt.accept {
+				req.Header.Add("Accept", v)
+			}
+			req.Header.Set("Content-Type", tt.ct)
+
+			mt, err := NegotiateMediaType(req, tt.opts...)
+			if tt.expectIs != nil {
+				assert.ErrorIs(t, err, tt.expectIs)
+				assert.Empty(t, mt)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, mt)
+		})
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      []string
+		want        string
+		expectError bool
+	}{
+		{
+			name: "no header uses identity",
+			want: "identity",
+		}, {
+			name:   "empty header uses identity",
+			accept: []string{""},
+			want:   "identity",
+		}, {
+			name:   "gzip",
+			accept: []string{"gzip"},
+			want:   "gzip",
+		}, {
+			name:   "highest q wins",
+			accept: []string{"gzip;q=0.5, deflate;q=0.8"},
+			want:   "deflate",
+		}, {
+			name:   "compression preferred over identity on ties",
+			accept: []string{"identity, zlib"},
+			want:   "zlib",
+		}, {
+			name:   "unsupported codings are ignored",
+			accept: []string{"br, zstd"},
+			want:   "identity",
+		}, {
+			name:   "wildcard",
+			accept: []string{"*"},
+			want:   "gzip",
+		}, {
+			name:   "wildcard with exclusion",
+			accept: []string{"*, gzip;q=0"},
+			want:   "deflate",
+		}, {
+			name:   "legacy x-gzip alias",
+			accept: []string{"x-gzip;q=0.9, deflate;q=0.5"},
+			want:   "gzip",
+		}, {
+			name:   "case insensitive and malformed q ignored",
+			accept: []string{"GZIP;Q=0.9, deflate;q=abc"},
+			want:   "gzip",
+		}, {
+			name:        "identity excluded",
+			accept:      []string{"br, identity;q=0"},
+			expectError: true,
+		}, {
+			name:        "everything excluded",
+			accept:      []string{"*;q=0"},
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/", nil)
+			for _, v := range tt.accept {
+				req.Header.Add("Accept-Encoding", v)
+			}
+
+			got, err := NegotiateEncoding(req)
+			if tt.expectError {
+				assert.ErrorIs(t, err, ErrNotAcceptableEncoding)
+				assert.Empty(t, got)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
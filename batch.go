@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// BatchResult is the outcome of one message of a batch, for example
+// http.StatusOK when it was accepted, http.StatusAccepted when it was deferred
+// or a 4xx or 5xx status with an Error when it was rejected.
+//
+// A batch result response holds one SimpleRequestResponse message per result,
+// with the addresses, TransactionUUID and Status of the result and the Error
+// as a plain text payload.  It can therefore be sent in any of the media
+// types, and the error replies written by WriteError have the same form.
+type BatchResult struct {
+	// TransactionUUID identifies the message the result is for.  It is empty
+	// for a message that could not be decoded.
+	TransactionUUID string
+
+	// Source and Destination are the addresses of the result message, which
+	// are the Destination and Source of the message the result is for.
+	Source      string
+	Destination string
+
+	// Status is the HTTP status code describing the outcome.
+	Status int
+
+	// Error describes why the message was rejected, if it was.  It is only
+	// read back from the results of a response for a 4xx or 5xx Status.
+	Error string
+}
+
+// NewBatchResult returns the result for msg with the provided status.  The
+// source and destination of msg are swapped and its TransactionUUID is echoed,
+// like NewErrorMessage.  The Error is the text of err, if there is one.  The
+// msg may be nil, for example for one of the DecodeFailures.
+func NewBatchResult(msg wrp.Union, status int, err error) BatchResult {
+	rv := BatchResult{Status: status}
+	var in wrp.Message
+	if !isNil(msg) &&
+		msg.To(&in, wrp.NoStandardValidation()) == nil {
+		rv.TransactionUUID = in.TransactionUUID
+		rv.Source = in.Destination
+		rv.Destination = in.Source
+	}
+	if err != nil {
+		rv.Error = err.Error()
+	}
+	return rv
+}
+
+// Message returns the message that carries the result in a batch result
+// response.
+func (r BatchResult) Message() *wrp.Message {
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          r.Source,
+		Destination:     r.Destination,
+		TransactionUUID: r.TransactionUUID,
+	}
+	msg.SetStatus(int64(r.Status))
+	if r.Error != "" {
+		msg.ContentType = "text/plain; charset=utf-8"
+		msg.Payload = []byte(r.Error)
+	}
+	return &msg
+}
+
+// BatchResultMessages returns the messages of a batch result response.  They
+// can be returned by a Handler, together with WithStatusPolicy(UniformStatus).
+func BatchResultMessages(results ...BatchResult) []wrp.Union {
+	rv := make([]wrp.Union, 0, len(results))
+	for _, r := range results {
+		rv = append(rv, r.Message())
+	}
+	return rv
+}
+
+// BatchResults converts the messages of a batch result response back into
+// results.  A message without a valid Status is given the status provided,
+// which is usually the HTTP status code of the response.
+func BatchResults(msgs []wrp.Union, status int) ([]BatchResult, error) {
+	rv := make([]BatchResult, 0, len(msgs))
+	for i, msg := range msgs {
+		r, err := batchResult(msg, status)
+		if err != nil {
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		rv = append(rv, r)
+	}
+	return rv, nil
+}
+
+func batchResult(msg wrp.Union, status int) (BatchResult, error) {
+	if isNil(msg) {
+		return BatchResult{}, fmt.Errorf("%w: message is nil", ErrInvalidMessage)
+	}
+
+	var m wrp.Message
+	if err := msg.To(&m, wrp.NoStandardValidation()); err != nil {
+		return BatchResult{}, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	rv := BatchResult{
+		TransactionUUID: m.TransactionUUID,
+		Source:          m.Source,
+		Destination:     m.Destination,
+		Status:          status,
+	}
+	if s, ok := messageStatus(&m); ok {
+		rv.Status = s
+	}
+
+	// The payload of an accepted message is a reply rather than an error.
+	if rv.Status >= http.StatusBadRequest {
+		rv.Error = string(m.Payload)
+	}
+	return rv, nil
+}
+
+// WriteBatchResponse writes the results as a batch result response.  The
+// status code is shared by all of the results, or http.StatusMultiStatus if
+// they differ.  The results are not checked by the validators of the Encoder.
+func (e *Encoder) WriteBatchResponse(w http.ResponseWriter, results ...BatchResult) error {
+	if len(results) == 0 {
+		return ErrNoMessages
+	}
+
+	msgs := BatchResultMessages(results...)
+
+	v := *e
+	v.validator = []wrp.Processor{wrp.NoStandardValidation()}
+	return v.WriteResponse(w, UniformStatus(msgs), msgs...)
+}
+
+// DecodeBatchResponse decodes a batch result response, see BatchResult.  A
+// message without a Status is given the HTTP status code of the response, and
+// a response without a body holds no results.
+func DecodeBatchResponse(resp *http.Response) ([]BatchResult, error) {
+	return (&Decoder{}).DecodeBatchResponse(resp)
+}
+
+// DecodeBatchResponse decodes a batch result response, enforcing the limits
+// configured on the Decoder.  The results are not checked by the validators of
+// the Decoder.  See the package level DecodeBatchResponse.
+func (d *Decoder) DecodeBatchResponse(resp *http.Response) ([]BatchResult, error) {
+	if resp == nil {
+		return nil, ErrNilResponse
+	}
+	if resp.StatusCode == http.StatusNoContent || resp.Header.Get("Content-Type") == "" {
+		return nil, nil
+	}
+
+	v := *d
+	v.validators = []wrp.Processor{wrp.NoStandardValidation()}
+	msgs, err := v.DecodeResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+	return BatchResults(msgs, resp.StatusCode)
+}
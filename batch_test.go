@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func TestBatchResponse(t *testing.T) {
+	mixed := []BatchResult{
+		{TransactionUUID: "uuid1", Source: "dns:server.example.com", Destination: "mac:112233445566", Status: http.StatusOK},
+		{TransactionUUID: "uuid2", Status: http.StatusBadRequest, Error: "invalid, \"quoted\"\nerror"},
+		{TransactionUUID: "uuid3", Status: http.StatusAccepted},
+		{Status: http.StatusBadRequest, Error: "decode failed (item 4)"},
+	}
+	uniform := []BatchResult{
+		{TransactionUUID: "uuid1", Status: http.StatusAccepted},
+		{TransactionUUID: "uuid2", Status: http.StatusAccepted},
+	}
+
+	for _, opts := range [][]Option{
+		{AsJSON()},
+		{AsMsgpack()},
+		{AsOctetStream()},
+		{AsJSONL()},
+		{AsMsgpackL(), EncodeGzip()},
+	} {
+		// The validators of the Encoder are not used for the results.
+		encoder, err := NewEncoder(append(opts, EncodeValidators(wrp.StandardValidator()))...)
+		require.NoError(t, err)
+
+		for _, test := range []struct {
+			results []BatchResult
+			status  int
+		}{
+			{mixed, http.StatusMultiStatus},
+			{uniform, http.StatusAccepted},
+			{mixed[1:2], http.StatusBadRequest},
+		} {
+			rec := httptest.NewRecorder()
+			require.NoError(t, encoder.WriteBatchResponse(rec, test.results...))
+
+			resp := rec.Result()
+			assert.Equal(t, test.status, resp.StatusCode)
+
+			got, err := DecodeBatchResponse(resp)
+			require.NoError(t, err)
+			assert.Equal(t, test.results, got)
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		encoder, err := NewEncoder()
+		require.NoError(t, err)
+		require.ErrorIs(t, encoder.WriteBatchResponse(httptest.NewRecorder()), ErrNoMessages)
+
+		_, err = DecodeBatchResponse(nil)
+		require.ErrorIs(t, err, ErrNilResponse)
+
+		got, err := DecodeBatchResponse(&http.Response{StatusCode: http.StatusNoContent, Body: http.NoBody})
+		require.NoError(t, err)
+		assert.Empty(t, got)
+
+		_, err = BatchResults([]wrp.Union{nil}, http.StatusOK)
+		require.ErrorIs(t, err, ErrInvalidMessage)
+	})
+}
+
+func TestBatchResults(t *testing.T) {
+	var withStatus wrp.Message
+	withStatus.TransactionUUID = "uuid1"
+	withStatus.SetStatus(http.StatusNotFound)
+
+	got, err := BatchResults([]wrp.Union{
+		&withStatus,
+		&wrp.Message{TransactionUUID: "uuid2"},
+		&wrp.Message{TransactionUUID: "uuid3", Payload: []byte(`{"reply":true}`)},
+		&wrp.Message{TransactionUUID: "uuid4", ContentType: "text/plain", Payload: []byte("plain text reply")},
+		&wrp.Message{TransactionUUID: "uuid5", Status: int64Ptr(http.StatusConflict), Payload: []byte("failed")},
+	}, http.StatusAccepted)
+	require.NoError(t, err)
+	assert.Equal(t, []BatchResult{
+		{TransactionUUID: "uuid1", Status: http.StatusNotFound},
+		{TransactionUUID: "uuid2", Status: http.StatusAccepted},
+		{TransactionUUID: "uuid3", Status: http.StatusAccepted},
+		{TransactionUUID: "uuid4", Status: http.StatusAccepted},
+		{TransactionUUID: "uuid5", Status: http.StatusConflict, Error: "failed"},
+	}, got)
+
+	assert.Equal(t,
+		BatchResult{
+			TransactionUUID: "uuid1",
+			Source:          "destination1",
+			Destination:     "source1",
+			Status:          http.StatusBadRequest,
+			Error:           "bad",
+		},
+		NewBatchResult(&testWRPMessages[0], http.StatusBadRequest, errors.New("bad")))
+	assert.Equal(t,
+		BatchResult{Status: http.StatusBadRequest},
+		NewBatchResult(nil, http.StatusBadRequest, nil))
+
+	msg := NewBatchResult(&wrp.Message{
+		Type:            wrp.SimpleEventMessageType,
+		Source:          "mac:112233445566",
+		Destination:     "dns:server.example.com",
+		TransactionUUID: "uuid1",
+	}, http.StatusOK, nil).Message()
+	assert.Empty(t, msg.ContentType)
+	assert.Empty(t, msg.Payload)
+	assert.Equal(t, wrp.SimpleRequestResponseMessageType, msg.Type)
+	assert.Equal(t, "dns:server.example.com", msg.Source)
+	assert.Equal(t, "mac:112233445566", msg.Destination)
+	assert.NoError(t, msg.Validate(wrp.StandardValidator()))
+}
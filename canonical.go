@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"maps"
+	"mime/multipart"
+	"net/textproto"
+	"slices"
+	"strings"
+
+	"github.com/tinylib/msgp/msgp"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// newBoundary returns the boundary of a multipart body that is not
+// canonical.
+func (e *Encoder) newBoundary() string {
+	if e.boundary != "" {
+		return e.boundary
+	}
+	return multipart.NewWriter(io.Discard).Boundary()
+}
+
+// rawPart is a multipart part as it is written.
+type rawPart struct {
+	header textproto.MIMEHeader
+	body   []byte
+}
+
+// asCanonicalMultipart encodes the parts up front so the boundary can be
+// derived from them.  A fixed boundary would be known in advance and could be
+// placed in a payload to forge extra parts.
+func (e *Encoder) asCanonicalMultipart(fn func(*multipart.Writer, flusher) error) (string, bodyWriter) {
+	parts, err := e.collectParts(fn)
+	if err != nil {
+		return e.newBoundary(), func(io.Writer, flusher) error {
+			return err
+		}
+	}
+
+	boundary := partsBoundary(parts)
+	return boundary, func(w io.Writer, flush flusher) error {
+		return e.writeMultipart(w, flush, boundary, func(mw *multipart.Writer, _ flusher) error {
+			for _, p := range parts {
+				pw, err := mw.CreatePart(p.header)
+				if err != nil {
+					return err
+				}
+				if _, err = pw.Write(p.body); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// collectParts runs fn and returns the parts it created.  A random boundary
+// is used while collecting them, so no payload can interfere.
+func (e *Encoder) collectParts(fn func(*multipart.Writer, flusher) error) ([]rawPart, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	if err := fn(mw, nil); err != nil {
+		return nil, err
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var parts []rawPart
+	mr := multipart.NewReader(&buf, mw.Boundary())
+	for {
+		part, err := mr.NextRawPart()
+		if err == io.EOF { // nolint: errorlint
+			return parts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, rawPart{header: part.Header, body: body})
+	}
+}
+
+// partsBoundary derives the boundary from a hash of the parts.  In the
+// unlikely case a part contains the boundary, the hash is hashed again until
+// one is found that none of them contain.
+func partsBoundary(parts []rawPart) string {
+	h := sha256.New()
+	for _, p := range parts {
+		for _, k := range slices.Sorted(maps.Keys(p.header)) {
+			for _, v := range p.header[k] {
+				fmt.Fprintf(h, "%s: %s\r\n", k, v)
+			}
+		}
+		fmt.Fprintf(h, "%d\r\n", len(p.body))
+		h.Write(p.body)
+	}
+
+	sum := h.Sum(nil)
+	for {
+		boundary := "wrp-" + hex.EncodeToString(sum[:24])
+		if !partsContain(parts, boundary) {
+			return boundary
+		}
+		next := sha256.Sum256(sum)
+		sum = next[:]
+	}
+}
+
+func partsContain(parts []rawPart, s string) bool {
+	for _, p := range parts {
+		if bytes.Contains(p.body, []byte(s)) {
+			return true
+		}
+		for _, values := range p.header {
+			for _, v := range values {
+				if strings.Contains(v, s) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// canonicalize sorts the PartnerIDs of a message that belongs to the caller,
+// so the message itself is left unchanged.
+func canonicalize(m *wrp.Message) {
+	if len(m.PartnerIDs) > 1 {
+		m.PartnerIDs = slices.Clone(m.PartnerIDs)
+		slices.Sort(m.PartnerIDs)
+	}
+}
+
+// sortMsgpackMetadata rewrites an encoded message so the entries of its
+// metadata map are in key order.  The generated msgpack encoder writes them
+// in map iteration order.
+func sortMsgpackMetadata(b []byte) ([]byte, error) {
+	n, rest, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := msgp.AppendMapHeader(make([]byte, 0, len(b)), n)
+	for range n {
+		var key string
+		key, rest, err = msgp.ReadStringBytes(rest)
+		if err != nil {
+			return nil, err
+		}
+		out = msgp.AppendString(out, key)
+
+		if key == "metadata" && !msgp.IsNil(rest) {
+			var meta map[string]string
+			meta, rest, err = readStringMap(rest)
+			if err != nil {
+				return nil, err
+			}
+			out = appendSortedMap(out, meta)
+			continue
+		}
+
+		value := rest
+		rest, err = msgp.Skip(rest)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value[:len(value)-len(rest)]...)
+	}
+
+	return append(out, rest...), nil
+}
+
+func readStringMap(b []byte) (map[string]string, []byte, error) {
+	n, b, err := msgp.ReadMapHeaderBytes(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	m := make(map[string]string, n)
+	for range n {
+		var k, v string
+		if k, b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, nil, err
+		}
+		if v, b, err = msgp.ReadStringBytes(b); err != nil {
+			return nil, nil, err
+		}
+		m[k] = v
+	}
+	return m, b, nil
+}
+
+func appendSortedMap(b []byte, m map[string]string) []byte {
+	keys := slices.Sorted(maps.Keys(m))
+	b = msgp.AppendMapHeader(b, uint32(len(keys))) // nolint: gosec
+	for _, k := range keys {
+		b = msgp.AppendString(b, k)
+		b = msgp.AppendString(b, m[k])
+	}
+	return b
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func TestCanonical(t *testing.T) {
+	many := make(map[string]string)
+	for _, k := range strings.Split("a b c d e f g h i j k l m n o p", " ") {
+		many["/"+k] = strings.ToUpper(k)
+	}
+
+	msgs := make([]wrp.Union, 0, len(fullMessages)+1)
+	for i := range fullMessages {
+		msgs = append(msgs, &fullMessages[i])
+	}
+	msgs = append(msgs, &wrp.Message{
+		Type:       wrp.SimpleEventMessageType,
+		Source:     "mac:112233445566",
+		Metadata:   many,
+		Payload:    []byte("payload"),
+		PartnerIDs: []string{"zebra", "alpha", "mango"},
+	})
+
+	tests := []struct {
+		name string
+		opts []Option
+	}{
+		{"json", []Option{AsJSON()}},
+		{"msgpack", []Option{AsMsgpack()}},
+		{"msgpack, gzip", []Option{AsMsgpack(), EncodeGzip(), CompressEnvelope()}},
+		{"octet-stream", []Option{AsOctetStream()}},
+		{"jsonl", []Option{AsJSONL()}},
+		{"msgpackl, chunked", []Option{AsMsgpackL(), WithMaxItemsPerChunk(1)}},
+		{"digests", []Option{AsMsgpack(), WithPartDigests(), WithContentDigest()}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append(test.opts, Canonical(), EncodeValidators(wrp.NoStandardValidation()))
+
+			var first http.Header
+			var firstBody []byte
+			for range 10 {
+				encoder, err := NewEncoder(opts...)
+				require.NoError(t, err)
+
+				headers, body, err := encoder.ToParts(msgs...)
+				require.NoError(t, err)
+				data, err := io.ReadAll(body)
+				require.NoError(t, err)
+
+				if first == nil {
+					first, firstBody = headers, data
+					continue
+				}
+				assert.Equal(t, first, headers)
+				assert.Equal(t, firstBody, data)
+			}
+
+			got, err := DecodeFromParts(first, io.NopCloser(strings.NewReader(string(firstBody))), wrp.NoStandardValidation())
+			require.NoError(t, err)
+			require.Len(t, got, len(msgs))
+			for i, msg := range got {
+				want := *msgs[i].(*wrp.Message)
+				want.PartnerIDs = slices.Sorted(slices.Values(want.PartnerIDs))
+				assert.Equal(t, &want, msg)
+			}
+		})
+	}
+
+	// The messages themselves are not changed.
+	assert.Equal(t, []string{"zebra", "alpha", "mango"}, msgs[len(msgs)-1].(*wrp.Message).PartnerIDs)
+}
+
+func TestWithBoundary(t *testing.T) {
+	msgs := toUnion(testWRPMessages)
+
+	encoder, err := NewEncoder(AsJSON(), WithBoundary("my-boundary"), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+	headers, body, err := encoder.ToParts(msgs...)
+	require.NoError(t, err)
+	assert.Equal(t, "multipart/mixed; boundary=my-boundary", headers.Get("Content-Type"))
+	got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+	require.NoError(t, err)
+	assert.Len(t, got, len(msgs))
+
+	encoder, err = NewEncoder(AsJSON(), Canonical(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+	headers, _, err = encoder.ToParts(msgs...)
+	require.NoError(t, err)
+	assert.Regexp(t, `^multipart/mixed; boundary=wrp-[0-9a-f]{48}$`, headers.Get("Content-Type"))
+
+	for _, invalid := range []string{"", "bad\nboundary", strings.Repeat("x", 71)} {
+		_, err = NewEncoder(WithBoundary(invalid))
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCanonicalBoundaryInjection(t *testing.T) {
+	encoder, err := NewEncoder(AsOctetStream(), Canonical())
+	require.NoError(t, err)
+
+	msg := func(payload string) wrp.Union {
+		return &wrp.Message{
+			Type:        wrp.SimpleEventMessageType,
+			Source:      "mac:112233445566",
+			Destination: "event:device-status",
+			Payload:     []byte(payload),
+		}
+	}
+	boundaryOf := func(payload string) string {
+		headers, _, err := encoder.ToParts(msg("first"), msg(payload))
+		require.NoError(t, err)
+		_, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+		require.NoError(t, err)
+		return params["boundary"]
+	}
+
+	// A payload that closes its own part with a boundary and forges another.
+	forge := func(boundary string) string {
+		return "x\r\n--" + boundary + "\r\n" +
+			"Content-Type: application/octet-stream\r\n" +
+			"X-Xmidt-Message-Type: SimpleEvent\r\n" +
+			"X-Xmidt-Source: mac:forged\r\n" +
+			"X-Xmidt-Destination: event:device-status\r\n\r\n" +
+			"forged"
+	}
+
+	for _, payload := range []string{
+		forge(boundaryOf("x")),
+		forge(boundaryOf("")),
+		forge("wrp-canonical-5f0c3a9e71b24d68a3e9c0f1d2b7e4a6"),
+	} {
+		headers, body, err := encoder.ToParts(msg("first"), msg(payload))
+		require.NoError(t, err)
+
+		got, err := DecodeFromParts(headers, io.NopCloser(body))
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		for _, m := range got {
+			assert.Equal(t, "mac:112233445566", m.(*wrp.Message).Source)
+		}
+		assert.Equal(t, []byte(payload), got[1].(*wrp.Message).Payload)
+		assert.Equal(t, boundaryOf(payload), boundaryOf(payload))
+	}
+}
@@ -0,0 +1,321 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// defaultAccept lists the media types the Client accepts in response, in the
+// same order the server side prefers them.
+var defaultAccept = strings.Join([]string{
+	MEDIA_TYPE_MSGPACKL,
+	MEDIA_TYPE_MSGPACK,
+	MEDIA_TYPE_JSONL,
+	MEDIA_TYPE_JSON,
+	MEDIA_TYPE_OCTET_STREAM,
+}, ", ")
+
+// Client sends WRP messages over HTTP and decodes the messages in the reply.
+// The Client is safe for concurrent use once created.
+type Client struct {
+	client      *http.Client
+	encoderOpts []Option
+	decoder     *Decoder
+	batch       *Decoder
+	decoderOpts []DecoderOption
+	accept      string
+}
+
+// ClientOption is a functional option for configuring the Client.  The options
+// are applied in the order they are provided.
+type ClientOption interface {
+	apply(*Client) error
+}
+
+// NewClient creates a new Client with the provided options.  The options are
+// applied in the order they are provided.
+//
+//	The defaults are:
+//	 - http.DefaultClient is used to send requests
+//	 - messages are encoded using the NewEncoder defaults
+//	 - every media type the decoders understand is accepted in response
+func NewClient(opts ...ClientOption) (*Client, error) {
+	client := Client{
+		client: http.DefaultClient,
+		accept: defaultAccept,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&client); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	decoder, err := NewDecoder(client.decoderOpts...)
+	if err != nil {
+		return nil, err
+	}
+	client.decoder = decoder
+
+	// Batch results are never checked by the validators, see
+	// DecodeBatchResponse.
+	batch := *decoder
+	batch.validators = []wrp.Processor{wrp.NoStandardValidation()}
+	client.batch = &batch
+
+	// Make sure the encoder options are valid before any request is made.
+	if _, err := NewEncoder(client.encoderOpts...); err != nil {
+		return nil, err
+	}
+
+	return &client, nil
+}
+
+// Response is the reply to messages sent by the Client.
+type Response struct {
+	// StatusCode is the HTTP status code of the reply.
+	StatusCode int
+
+	// Header holds the HTTP headers of the reply.
+	Header http.Header
+
+	// Messages holds the decoded messages of the reply, if any.
+	Messages []wrp.Union
+}
+
+// Do encodes the messages into a request with the provided method and URL,
+// sends it and decodes the messages in the reply.  A reply without a body is
+// returned with no messages.  A reply with a status code outside of the 2xx
+// range that does not hold WRP messages results in a *ResponseError.
+func (c *Client) Do(ctx context.Context, method, url string, msgs ...wrp.Union) (*Response, error) {
+	return c.do(ctx, false, method, url, msgs...)
+}
+
+// do sends the messages.  A batch is decoded without the validators of the
+// Client, and a batch reply without a body is accepted whatever its status, so
+// its status can become the result of every message.
+func (c *Client) do(ctx context.Context, batch bool, method, url string, msgs ...wrp.Union) (*Response, error) {
+	encoder, err := NewEncoder(c.encoderOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := encoder.NewRequestWithContext(ctx, method, url, msgs...)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", c.accept)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if batch {
+		return readResponse(c.batch, resp, true)
+	}
+	return readResponse(c.decoder, resp, false)
+}
+
+func readResponse(decoder *Decoder, resp *http.Response, emptyOK bool) (*Response, error) {
+	rv := Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+	}
+	ok := resp.StatusCode >= 200 && resp.StatusCode < 300
+
+	if resp.StatusCode == http.StatusNoContent || resp.Header.Get("Content-Type") == "" {
+		if !ok && !emptyOK {
+			return nil, &ResponseError{StatusCode: resp.StatusCode}
+		}
+		return &rv, nil
+	}
+
+	msgs, err := decoder.DecodeResponse(resp)
+	if err != nil {
+		if !ok {
+			return nil, &ResponseError{StatusCode: resp.StatusCode, Err: err}
+		}
+		return nil, err
+	}
+
+	rv.Messages = msgs
+	return &rv, nil
+}
+
+// Transaction is the result of Client.Transact.
+type Transaction struct {
+	*Response
+
+	// Replies holds one entry for each message sent, in the order they were
+	// provided.
+	Replies []Reply
+
+	// Unexpected holds the messages in the response that did not match any
+	// message that was sent, or that repeated a reply already received.
+	Unexpected []wrp.Union
+}
+
+// Reply pairs a message that was sent with the reply to it.
+type Reply struct {
+	// Request is the message that was sent.
+	Request wrp.Union
+
+	// Response is the message with the same TransactionUUID as Request, or nil
+	// if none was received.
+	Response wrp.Union
+
+	// Err is ErrNoReply if no reply was received, otherwise nil.
+	Err error
+}
+
+// Missing returns the messages that did not receive a reply.
+func (t *Transaction) Missing() []wrp.Union {
+	var rv []wrp.Union
+	for _, r := range t.Replies {
+		if r.Response == nil {
+			rv = append(rv, r.Request)
+		}
+	}
+	return rv
+}
+
+// Transact sends the messages like Do and matches each message in the reply
+// to the message sent with the same TransactionUUID.  This is intended for
+// SimpleRequestResponse traffic.  Every message sent must have a unique
+// TransactionUUID, otherwise an error matching ErrInvalidMessage is returned
+// before anything is sent.
+func (c *Client) Transact(ctx context.Context, method, url string, msgs ...wrp.Union) (*Transaction, error) {
+	return c.transact(ctx, false, method, url, msgs...)
+}
+
+func (c *Client) transact(ctx context.Context, batch bool, method, url string, msgs ...wrp.Union) (*Transaction, error) {
+	index := make(map[string]int, len(msgs))
+	for i, msg := range msgs {
+		id, err := transactionUUID(msg)
+		if err != nil {
+			return nil, fmt.Errorf("%w: message %d: %w", ErrInvalidMessage, i, err)
+		}
+		if id == "" {
+			return nil, fmt.Errorf("%w: message %d has no transaction uuid", ErrInvalidMessage, i)
+		}
+		if _, found := index[id]; found {
+			return nil, fmt.Errorf("%w: message %d repeats transaction uuid %q", ErrInvalidMessage, i, id)
+		}
+		index[id] = i
+	}
+
+	resp, err := c.do(ctx, batch, method, url, msgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	t := Transaction{
+		Response: resp,
+		Replies:  make([]Reply, len(msgs)),
+	}
+	for i, msg := range msgs {
+		t.Replies[i].Request = msg
+	}
+
+	for _, msg := range resp.Messages {
+		id, _ := transactionUUID(msg)
+		i, found := index[id]
+		if !found || t.Replies[i].Response != nil {
+			t.Unexpected = append(t.Unexpected, msg)
+			continue
+		}
+		t.Replies[i].Response = msg
+	}
+
+	for i := range t.Replies {
+		if t.Replies[i].Response == nil {
+			t.Replies[i].Err = ErrNoReply
+		}
+	}
+
+	return &t, nil
+}
+
+// BatchResponse is the result of Client.SendBatch.
+type BatchResponse struct {
+	*Response
+
+	// Results holds the result of each message sent, in the order they were
+	// provided.
+	Results []BatchResult
+
+	// Unexpected holds the results that did not match any message that was
+	// sent, for example those for messages the server could not decode.
+	Unexpected []BatchResult
+}
+
+// SendBatch sends the messages like Transact and returns the result of each
+// one from the batch result response, see BatchResult.  Every message sent
+// must have a unique TransactionUUID.
+//
+// If the response holds no messages, every message is given the HTTP status
+// code of the response, so a server that answers a batch with a single status
+// is understood as well.  This includes a 4xx or 5xx status, in which case
+// the Error describes a *ResponseError.  Otherwise a message without a result
+// has a Status of 0 and an Error describing ErrNoReply.
+func (c *Client) SendBatch(ctx context.Context, method, url string, msgs ...wrp.Union) (*BatchResponse, error) {
+	t, err := c.transact(ctx, true, method, url, msgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	// A reply without messages rejects the whole batch if its status does.
+	var rejected error
+	if t.StatusCode >= http.StatusBadRequest {
+		rejected = &ResponseError{StatusCode: t.StatusCode}
+	}
+
+	rv := BatchResponse{
+		Response: t.Response,
+		Results:  make([]BatchResult, len(t.Replies)),
+	}
+	for i, r := range t.Replies {
+		switch {
+		case r.Response != nil:
+			rv.Results[i], err = batchResult(r.Response, t.StatusCode)
+			if err != nil {
+				return nil, err
+			}
+		case len(t.Messages) == 0:
+			rv.Results[i] = NewBatchResult(r.Request, t.StatusCode, rejected)
+		default:
+			rv.Results[i] = NewBatchResult(r.Request, 0, r.Err)
+		}
+	}
+
+	rv.Unexpected, err = BatchResults(t.Unexpected, t.StatusCode)
+	if err != nil {
+		return nil, err
+	}
+
+	return &rv, nil
+}
+
+func transactionUUID(msg wrp.Union) (string, error) {
+	if isNil(msg) {
+		return "", errors.New("message is nil")
+	}
+
+	var m wrp.Message
+	if err := msg.To(&m, wrp.NoStandardValidation()); err != nil {
+		return "", err
+	}
+	return m.TransactionUUID, nil
+}
@@ -0,0 +1,291 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// newTestServer serves fn and records the Accept header of the last request.
+func newTestServer(t *testing.T, fn HandlerFunc, accept *string) *httptest.Server {
+	t.Helper()
+
+	h, err := NewHTTPHandler(fn,
+		WithEncoderOptions(EncodeValidators(wrp.NoStandardValidation())),
+		WithDecoderOptions(DecodeValidators(wrp.NoStandardValidation())),
+	)
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if accept != nil {
+			*accept = r.Header.Get("Accept")
+		}
+		h.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func newTestClient(t *testing.T, opts ...ClientOption) *Client {
+	t.Helper()
+
+	opts = append([]ClientOption{
+		WithClientEncoderOptions(EncodeValidators(wrp.NoStandardValidation())),
+		WithClientDecoderOptions(DecodeValidators(wrp.NoStandardValidation())),
+	}, opts...)
+	client, err := NewClient(opts...)
+	require.NoError(t, err)
+	return client
+}
+
+func TestClientDo(t *testing.T) {
+	t.Run("echo", func(t *testing.T) {
+		var accept string
+		server := newTestServer(t, echo, &accept)
+		client := newTestClient(t, WithAcceptedMediaTypes(MEDIA_TYPE_JSONL))
+
+		resp, err := client.Do(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, MEDIA_TYPE_JSONL, accept)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, MEDIA_TYPE_JSONL, resp.Header.Get("Content-Type"))
+		require.Len(t, resp.Messages, len(testWRPMessages))
+		for i := range testWRPMessages {
+			assert.Equal(t, testWRPMessages[i], *resp.Messages[i].(*wrp.Message))
+		}
+	})
+
+	t.Run("default accept", func(t *testing.T) {
+		var accept string
+		server := newTestServer(t, echo, &accept)
+		client := newTestClient(t)
+
+		resp, err := client.Do(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, defaultAccept, accept)
+		assert.Len(t, resp.Messages, len(testWRPMessages))
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		server := newTestServer(t, func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+			return nil, nil
+		}, nil)
+		client := newTestClient(t)
+
+		resp, err := client.Do(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+		assert.Empty(t, resp.Messages)
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		server := newTestServer(t, func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+			return nil, errors.New("failed")
+		}, nil)
+		client := newTestClient(t)
+
+		resp, err := client.Do(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+		require.Len(t, resp.Messages, len(testWRPMessages))
+		for i, msg := range resp.Messages {
+			m := msg.(*wrp.Message)
+			assert.Equal(t, testWRPMessages[i].TransactionUUID, m.TransactionUUID)
+			require.NotNil(t, m.Status)
+			assert.Equal(t, int64(http.StatusInternalServerError), *m.Status)
+			assert.Equal(t, "failed", string(m.Payload))
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			http.Error(w, "failed", http.StatusInternalServerError)
+		}))
+		t.Cleanup(server.Close)
+		client := newTestClient(t)
+
+		resp, err := client.Do(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+		var re *ResponseError
+		require.ErrorAs(t, err, &re)
+		assert.Equal(t, http.StatusInternalServerError, re.StatusCode)
+		assert.Nil(t, resp)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewClient(WithHTTPClient(nil))
+		require.Error(t, err)
+
+		_, err = NewClient(WithAcceptedMediaTypes("text/html"))
+		require.ErrorIs(t, err, ErrUnsupportedMediaType)
+
+		_, err = NewClient(WithClientEncoderOptions(AsMediaType("invalid")))
+		require.Error(t, err)
+	})
+}
+
+func TestClientTransact(t *testing.T) {
+	reply := func(id string) wrp.Union {
+		return &wrp.Message{
+			Type:            wrp.SimpleRequestResponseMessageType,
+			Source:          "device",
+			Destination:     "service",
+			TransactionUUID: id,
+		}
+	}
+
+	server := newTestServer(t, func(_ context.Context, msgs []wrp.Union) ([]wrp.Union, error) {
+		// Reply out of order, skip uuid2 and add an unknown reply.
+		return []wrp.Union{reply("uuid3"), reply("uuid1"), reply("uuid1"), reply("unknown")}, nil
+	}, nil)
+	client := newTestClient(t)
+
+	txn, err := client.Transact(context.Background(), http.MethodPost, server.URL, toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+	require.Len(t, txn.Replies, 3)
+
+	assert.Equal(t, "uuid1", txn.Replies[0].Response.(*wrp.Message).TransactionUUID)
+	require.NoError(t, txn.Replies[0].Err)
+	assert.Nil(t, txn.Replies[1].Response)
+	require.ErrorIs(t, txn.Replies[1].Err, ErrNoReply)
+	assert.Equal(t, "uuid3", txn.Replies[2].Response.(*wrp.Message).TransactionUUID)
+
+	assert.Len(t, txn.Unexpected, 2)
+	assert.Equal(t, []wrp.Union{txn.Replies[1].Request}, txn.Missing())
+
+	t.Run("missing transaction uuid", func(t *testing.T) {
+		msg := testWRPMessages[0]
+		msg.TransactionUUID = ""
+		_, err := client.Transact(context.Background(), http.MethodPost, server.URL, &msg)
+		require.ErrorIs(t, err, ErrInvalidMessage)
+	})
+
+	t.Run("repeated transaction uuid", func(t *testing.T) {
+		msgs := toUnion([]wrp.Message{testWRPMessages[0], testWRPMessages[0]})
+		_, err := client.Transact(context.Background(), http.MethodPost, server.URL, msgs...)
+		require.ErrorIs(t, err, ErrInvalidMessage)
+	})
+}
+
+func TestClientSendBatch(t *testing.T) {
+	h, err := NewHTTPHandler(
+		HandlerFunc(func(ctx context.Context, msgs []wrp.Union) ([]wrp.Union, error) {
+			var results []BatchResult
+			for _, msg := range msgs {
+				switch msg.(*wrp.Message).TransactionUUID {
+				case "uuid1":
+					results = append(results, NewBatchResult(msg, http.StatusOK, nil))
+				case "uuid2":
+					results = append(results, NewBatchResult(msg, http.StatusAccepted, nil))
+				}
+			}
+			for _, f := range DecodeFailures(ctx) {
+				results = append(results, NewBatchResult(nil, StatusCode(f), f))
+			}
+			return BatchResultMessages(results...), nil
+		}),
+		WithDecoderOptions(LenientDecoding(), DecodeValidators(
+			wrp.NoStandardValidation(),
+			wrp.ProcessorFunc(func(_ context.Context, msg wrp.Message) error {
+				if msg.TransactionUUID == "uuid3" {
+					return errors.New("rejected")
+				}
+				return nil
+			}),
+		)),
+		// The test addresses are not locators, and the results of the decode
+		// failures have no TransactionUUID.
+		WithEncoderOptions(EncodeValidators(wrp.NoStandardValidation())),
+		WithStatusPolicy(UniformStatus),
+	)
+	require.NoError(t, err)
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+
+	// The client validates the messages it decodes, which the results would
+	// not pass.
+	client, err := NewClient(
+		WithClientEncoderOptions(AsJSONL(), EncodeValidators(wrp.NoStandardValidation())),
+		WithAcceptedMediaTypes(MEDIA_TYPE_MSGPACK),
+	)
+	require.NoError(t, err)
+
+	msgs := toUnion(testWRPMessages)
+
+	resp, err := client.SendBatch(context.Background(), http.MethodPost, server.URL, msgs...)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Equal(t, []BatchResult{
+		{TransactionUUID: "uuid1", Source: "destination1", Destination: "source1", Status: http.StatusOK},
+		{TransactionUUID: "uuid2", Source: "destination2", Destination: "source2", Status: http.StatusAccepted},
+		{TransactionUUID: "uuid3", Source: "destination3", Destination: "source3", Error: ErrNoReply.Error()},
+	}, resp.Results)
+	require.Len(t, resp.Unexpected, 1)
+	assert.Equal(t, http.StatusBadRequest, resp.Unexpected[0].Status)
+	assert.Contains(t, resp.Unexpected[0].Error, "item 3")
+
+	t.Run("single status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusAccepted)
+		}))
+		t.Cleanup(server.Close)
+
+		resp, err := client.SendBatch(context.Background(), http.MethodPost, server.URL, msgs...)
+		require.NoError(t, err)
+		require.Len(t, resp.Results, len(msgs))
+		for i, r := range resp.Results {
+			assert.Equal(t, testWRPMessages[i].TransactionUUID, r.TransactionUUID)
+			assert.Equal(t, http.StatusAccepted, r.Status)
+			assert.Empty(t, r.Error)
+		}
+	})
+
+	t.Run("single failure status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		t.Cleanup(server.Close)
+
+		resp, err := client.SendBatch(context.Background(), http.MethodPost, server.URL, msgs...)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		require.Len(t, resp.Results, len(msgs))
+		for i, r := range resp.Results {
+			assert.Equal(t, testWRPMessages[i].TransactionUUID, r.TransactionUUID)
+			assert.Equal(t, http.StatusServiceUnavailable, r.Status)
+			assert.Equal(t, "unexpected response status 503", r.Error)
+		}
+
+		// Do still reports the failure as an error.
+		_, err = client.Do(context.Background(), http.MethodPost, server.URL, msgs...)
+		var re *ResponseError
+		require.ErrorAs(t, err, &re)
+		assert.Equal(t, http.StatusServiceUnavailable, re.StatusCode)
+	})
+
+	t.Run("error reply", func(t *testing.T) {
+		server := newTestServer(t, func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+			return nil, errors.New("failed")
+		}, nil)
+
+		resp, err := client.SendBatch(context.Background(), http.MethodPost, server.URL, msgs[:2]...)
+		require.NoError(t, err)
+		for i, r := range resp.Results {
+			assert.Equal(t, BatchResult{
+				TransactionUUID: testWRPMessages[i].TransactionUUID,
+				Source:          testWRPMessages[i].Destination,
+				Destination:     testWRPMessages[i].Source,
+				Status:          http.StatusInternalServerError,
+				Error:           "failed",
+			}, r)
+		}
+	})
+}
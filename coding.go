@@ -0,0 +1,329 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// ContentCoding is an HTTP content coding (RFC 9110, Section 8.4.1) that can
+// be used to compress encoded messages and to decompress them when decoding.
+type ContentCoding interface {
+	// Name returns the token used in the Content-Encoding and Accept-Encoding
+	// headers, for example "gzip".
+	Name() string
+
+	// Compress wraps w so that everything written is encoded.  Closing the
+	// returned writer must flush any buffered data but must not close w.
+	Compress(w io.Writer) (io.WriteCloser, error)
+
+	// Decompress wraps r so that everything read is decoded.
+	Decompress(r io.Reader) (io.ReadCloser, error)
+}
+
+// NewContentCoding creates a ContentCoding from the provided name and
+// functions.  This is a convenience for registering codings such as brotli or
+// zstd without defining a new type.
+func NewContentCoding(name string,
+	compress func(io.Writer) (io.WriteCloser, error),
+	decompress func(io.Reader) (io.ReadCloser, error),
+) ContentCoding {
+	return funcCoding{
+		name:       strings.ToLower(name),
+		compress:   compress,
+		decompress: decompress,
+	}
+}
+
+type funcCoding struct {
+	name       string
+	compress   func(io.Writer) (io.WriteCloser, error)
+	decompress func(io.Reader) (io.ReadCloser, error)
+}
+
+func (c funcCoding) Name() string {
+	return c.name
+}
+
+func (c funcCoding) Compress(w io.Writer) (io.WriteCloser, error) {
+	return c.compress(w)
+}
+
+func (c funcCoding) Decompress(r io.Reader) (io.ReadCloser, error) {
+	return c.decompress(r)
+}
+
+const identityCoding = "identity"
+
+var (
+	codingsLock sync.RWMutex
+	codings     = map[string]ContentCoding{}
+	codingOrder []string
+
+	// codingAliases maps the legacy names from RFC 9110, Section 8.4.1 to
+	// the coding they are equivalent to.
+	codingAliases = map[string]string{
+		"x-gzip": "gzip",
+	}
+)
+
+func init() {
+	for _, c := range []ContentCoding{
+		gzipCoding(gzip.DefaultCompression),
+		deflateCoding(flate.DefaultCompression),
+		zlibCoding(zlib.DefaultCompression),
+		identity(),
+	} {
+		if err := RegisterContentCoding(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// RegisterContentCoding makes a content coding available to the Encoder and
+// the decoders.  Registering a coding with the same name as an existing one
+// replaces it, which allows the built in gzip, deflate and zlib codings to be
+// swapped for other implementations.  The identity coding cannot be replaced.
+//
+// When negotiating, codings are preferred in the order they were first
+// registered, after the built in codings.
+func RegisterContentCoding(c ContentCoding) error {
+	if c == nil {
+		return errors.New("content coding is nil")
+	}
+
+	name := strings.ToLower(c.Name())
+	if name == "" {
+		return errors.New("content coding name is empty")
+	}
+
+	codingsLock.Lock()
+	defer codingsLock.Unlock()
+
+	if _, found := codings[name]; found {
+		if name == identityCoding {
+			return errors.New("the identity content coding cannot be replaced")
+		}
+	} else {
+		codingOrder = append(codingOrder, name)
+	}
+	codings[name] = c
+
+	return nil
+}
+
+// LookupContentCoding returns the registered content coding with the provided
+// name.  The lookup is case insensitive and understands the legacy "x-gzip"
+// alias.
+func LookupContentCoding(name string) (ContentCoding, bool) {
+	codingsLock.RLock()
+	defer codingsLock.RUnlock()
+
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := codingAliases[name]; ok {
+		name = alias
+	}
+
+	c, ok := codings[name]
+	return c, ok
+}
+
+// ContentCodings returns the names of the registered content codings in order
+// of preference.  Identity is always last.
+func ContentCodings() []string {
+	codingsLock.RLock()
+	defer codingsLock.RUnlock()
+
+	names := make([]string, 0, len(codingOrder))
+	for _, name := range codingOrder {
+		if name != identityCoding {
+			names = append(names, name)
+		}
+	}
+	return append(names, identityCoding)
+}
+
+func lookupContentCoding(name string) (ContentCoding, error) {
+	c, ok := LookupContentCoding(name)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedEncoding, name)
+	}
+	return c, nil
+}
+
+// parseCodings splits Content-Encoding header values into the list of codings
+// in the order they were applied.
+func parseCodings(values []string) []string {
+	var list []string
+	for _, value := range values {
+		for token := range strings.SplitSeq(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				list = append(list, token)
+			}
+		}
+	}
+	return list
+}
+
+// stackedCoding applies several codings in order, as described by a
+// Content-Encoding header listing more than one coding.
+type stackedCoding []ContentCoding
+
+// newStackedCoding returns the coding that applies the codings in order,
+// ignoring identity.
+func newStackedCoding(list ...ContentCoding) ContentCoding {
+	var s stackedCoding
+	for _, c := range list {
+		if c.Name() != identityCoding {
+			s = append(s, c)
+		}
+	}
+
+	switch len(s) {
+	case 0:
+		return identity()
+	case 1:
+		return s[0]
+	}
+	return s
+}
+
+func (s stackedCoding) Name() string {
+	names := make([]string, len(s))
+	for i, c := range s {
+		names[i] = c.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+// Compress applies the first coding first, so it wraps the writer for the
+// last coding.
+func (s stackedCoding) Compress(w io.Writer) (io.WriteCloser, error) {
+	closers := make([]io.Closer, 0, len(s))
+	for i := len(s) - 1; i >= 0; i-- {
+		cw, err := s[i].Compress(w)
+		if err != nil {
+			return nil, err
+		}
+		closers = append(closers, cw)
+		w = cw
+	}
+
+	return stackedWriter{
+		Writer:  w,
+		closers: closers,
+	}, nil
+}
+
+// Decompress undoes the codings in reverse order.
+func (s stackedCoding) Decompress(r io.Reader) (io.ReadCloser, error) {
+	closers := make([]io.Closer, 0, len(s))
+	for i := len(s) - 1; i >= 0; i-- {
+		rc, err := s[i].Decompress(r)
+		if err != nil {
+			_ = closeAll(closers)
+			return nil, err
+		}
+		closers = append(closers, rc)
+		r = rc
+	}
+
+	return readCloser{
+		Reader: r,
+		Closer: closerFunc(func() error {
+			return closeAll(closers)
+		}),
+	}, nil
+}
+
+// stackedWriter closes the outermost writer first so each coding flushes into
+// the next one.
+type stackedWriter struct {
+	io.Writer
+	closers []io.Closer
+}
+
+func (s stackedWriter) Close() error {
+	var errs []error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		errs = append(errs, s.closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+// closeAll closes the closers from the last to the first.
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for i := len(closers) - 1; i >= 0; i-- {
+		errs = append(errs, closers[i].Close())
+	}
+	return errors.Join(errs...)
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error {
+	return f()
+}
+
+// builtinCoding is one of the built in gzip, deflate and zlib codings, which
+// the Encoder can use at any compression level until they are replaced.
+type builtinCoding struct {
+	funcCoding
+}
+
+func gzipCoding(level int) ContentCoding {
+	return builtinCoding{funcCoding{
+		name: "gzip",
+		compress: func(w io.Writer) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		decompress: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	}}
+}
+
+func deflateCoding(level int) ContentCoding {
+	return builtinCoding{funcCoding{
+		name: "deflate",
+		compress: func(w io.Writer) (io.WriteCloser, error) {
+			return flate.NewWriter(w, level)
+		},
+		decompress: func(r io.Reader) (io.ReadCloser, error) {
+			return flate.NewReader(r), nil
+		},
+	}}
+}
+
+func zlibCoding(level int) ContentCoding {
+	return builtinCoding{funcCoding{
+		name: "zlib",
+		compress: func(w io.Writer) (io.WriteCloser, error) {
+			return zlib.NewWriterLevel(w, level)
+		},
+		decompress: func(r io.Reader) (io.ReadCloser, error) {
+			return zlib.NewReader(r)
+		},
+	}}
+}
+
+func identity() ContentCoding {
+	return NewContentCoding(identityCoding,
+		func(w io.Writer) (io.WriteCloser, error) {
+			return nopWriteCloser{
+				Writer: w,
+			}, nil
+		},
+		func(r io.Reader) (io.ReadCloser, error) {
+			return io.NopCloser(r), nil
+		})
+}
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"compress/flate"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// testCoding is a stand in for codings such as brotli or zstd that live in
+// other modules.
+var testCoding = NewContentCoding("X-Test",
+	func(w io.Writer) (io.WriteCloser, error) {
+		return flate.NewWriter(w, flate.BestSpeed)
+	},
+	func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	})
+
+func TestRegisterContentCoding(t *testing.T) {
+	require.NoError(t, RegisterContentCoding(testCoding))
+
+	c, ok := LookupContentCoding("x-test")
+	require.True(t, ok)
+	assert.Equal(t, "x-test", c.Name())
+
+	names := ContentCodings()
+	assert.Equal(t, []string{"gzip", "deflate", "zlib"}, names[:3])
+	assert.Contains(t, names, "x-test")
+	assert.Equal(t, identityCoding, names[len(names)-1])
+
+	assert.Error(t, RegisterContentCoding(nil))
+	assert.Error(t, RegisterContentCoding(NewContentCoding("", nil, nil)))
+	assert.Error(t, RegisterContentCoding(NewContentCoding("identity", nil, nil)))
+
+	_, ok = LookupContentCoding("unknown")
+	assert.False(t, ok)
+}
+
+func TestEncodeCoding(t *testing.T) {
+	require.NoError(t, RegisterContentCoding(testCoding))
+
+	t.Run("round trip", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), EncodeCoding("x-test"),
+			EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, "x-test", headers.Get("Content-Encoding"))
+
+		got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	})
+
+	t.Run("negotiated", func(t *testing.T) {
+		req := &http.Request{
+			Header: http.Header{
+				"Accept-Encoding": []string{"gzip, x-test"},
+			},
+		}
+
+		encoding, err := NegotiateEncoding(req, "x-test", "gzip")
+		require.NoError(t, err)
+		assert.Equal(t, "x-test", encoding)
+
+		encoding, err = NegotiateEncoding(req)
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", encoding)
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		encoder, err := NewEncoder(EncodeCoding("unknown"))
+		require.ErrorIs(t, err, ErrUnsupportedEncoding)
+		assert.Nil(t, encoder)
+	})
+}
+
+func TestReplacedBuiltinCoding(t *testing.T) {
+	var used int
+	replacement := NewContentCoding("zlib",
+		func(w io.Writer) (io.WriteCloser, error) {
+			used++
+			return zlib.NewWriter(w), nil
+		},
+		zlib.NewReader)
+	require.NoError(t, RegisterContentCoding(replacement))
+	t.Cleanup(func() {
+		require.NoError(t, RegisterContentCoding(zlibCoding(zlib.DefaultCompression)))
+	})
+
+	for _, opt := range []Option{EncodeZlib(), EncodeZlib(zlib.BestSpeed)} {
+		encoder, err := NewEncoder(AsJSON(), opt, EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages[:1])...)
+		require.NoError(t, err)
+		got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, 1)
+	}
+	assert.Equal(t, 2, used)
+}
+
+func TestStackedCodings(t *testing.T) {
+	t.Run("encode and decode", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), EncodeCodings("deflate", "identity", "gzip"),
+			EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, "deflate, gzip", headers.Get("Content-Encoding"))
+
+		got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	})
+
+	tests := []struct {
+		description string
+		encode      []string
+		header      []string
+		expectErr   error
+	}{
+		{
+			description: "gzip then identity",
+			encode:      []string{"gzip"},
+			header:      []string{"gzip, identity"},
+		}, {
+			description: "double gzip",
+			encode:      []string{"gzip", "gzip"},
+			header:      []string{"gzip, gzip"},
+		}, {
+			description: "x-gzip alias",
+			encode:      []string{"gzip"},
+			header:      []string{"X-Gzip"},
+		}, {
+			description: "multiple header values",
+			encode:      []string{"zlib", "gzip"},
+			header:      []string{"zlib", " gzip "},
+		}, {
+			description: "only identity",
+			header:      []string{"identity"},
+		}, {
+			description: "unknown coding",
+			encode:      []string{"gzip"},
+			header:      []string{"gzip, br"},
+			expectErr:   ErrUnsupportedEncoding,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.description, func(t *testing.T) {
+			encoder, err := NewEncoder(AsJSON(), EncodeCodings(tc.encode...),
+				EncodeValidators(wrp.NoStandardValidation()))
+			require.NoError(t, err)
+
+			headers, body, err := encoder.ToParts(toUnion(testWRPMessages[:1])...)
+			require.NoError(t, err)
+			headers["Content-Encoding"] = tc.header
+
+			got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+			if tc.expectErr != nil {
+				require.ErrorIs(t, err, tc.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, got, 1)
+		})
+	}
+
+	t.Run("unknown", func(t *testing.T) {
+		encoder, err := NewEncoder(EncodeCodings("gzip", "unknown"))
+		require.ErrorIs(t, err, ErrUnsupportedEncoding)
+		assert.Nil(t, encoder)
+	})
+}
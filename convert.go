@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// Converter wraps ordinary HTTP requests into SimpleRequestResponse messages
+// and turns the replies to them back into HTTP responses.  The Converter is
+// safe for concurrent use once created.
+//
+// A request is converted as follows:
+//   - the method is stored in Path and the request URI in URL
+//   - the headers selected with WithConvertedHeaders are stored in Headers,
+//     one "Name: value" entry per value, sorted by name
+//   - the Content-Type is stored in ContentType and the body in Payload
+//   - the TransactionUUID, Source, Destination, PartnerIDs, Metadata,
+//     SessionID, ServiceName and Accept are read from the same X-Xmidt-*
+//     headers used by the octet-stream media type, including
+//     X-Webpa-Device-Name for the Destination
+//
+// A request without a TransactionUUID is assigned a random one.
+//
+// Path and URL are not part of the SimpleRequestResponse definition, so the
+// standard WRP validation used by the Converter ignores them and the messages
+// must be encoded with EncodeValidators(wrp.NoStandardValidation()).
+type Converter struct {
+	headers    map[string]bool
+	source     string
+	maxBody    int64
+	validators []wrp.Processor
+}
+
+// ConverterOption is a functional option for configuring the Converter.  The
+// options are applied in the order they are provided.
+type ConverterOption interface {
+	apply(*Converter) error
+}
+
+// NewConverter creates a new Converter with the provided options.  By default
+// no headers other than Content-Type are converted, the body size is not
+// limited and the messages are checked with the standard WRP validators.
+func NewConverter(opts ...ConverterOption) (*Converter, error) {
+	c := Converter{
+		headers: make(map[string]bool),
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&c); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &c, nil
+}
+
+// ToMessage converts the request into a SimpleRequestResponse message.  The
+// request body is read and closed.
+func (c *Converter) ToMessage(r *http.Request) (*wrp.Message, error) {
+	if r == nil {
+		return nil, ErrNilRequest
+	}
+
+	msg := wrp.Message{
+		Type:        wrp.SimpleRequestResponseMessageType,
+		Source:      c.source,
+		Path:        r.Method,
+		URL:         r.URL.RequestURI(),
+		ContentType: r.Header.Get("Content-Type"),
+	}
+
+	h := wrpHeader{headers: r.Header}
+	h.readString(transactionUuidHeader, "TransactionUUID", &msg.TransactionUUID)
+	h.readString(sourceHeader, "Source", &msg.Source)
+	h.readString(destinationHeader, "Destination", &msg.Destination)
+	h.readString(acceptHeader, "Accept", &msg.Accept)
+	h.readString(sessionIdHeader, "SessionID", &msg.SessionID)
+	h.readString(serviceNameHeader, "ServiceName", &msg.ServiceName)
+	h.readStrings(partnerIdHeader, "PartnerIDs", &msg.PartnerIDs)
+	h.readHashmap(metadataHeader, "Metadata", &msg.Metadata)
+	if h.err != nil {
+		return nil, h.err
+	}
+
+	if msg.TransactionUUID == "" {
+		msg.TransactionUUID = rand.Text()
+	}
+
+	// The names are sorted so the same request always gives the same message.
+	for _, name := range slices.Sorted(maps.Keys(c.headers)) {
+		for _, v := range r.Header.Values(name) {
+			msg.Headers = append(msg.Headers, name+": "+v)
+		}
+	}
+
+	if r.Body != nil {
+		defer r.Body.Close()
+
+		body := io.Reader(r.Body)
+		if c.maxBody > 0 {
+			body = io.LimitReader(body, c.maxBody+1)
+		}
+
+		payload, err := io.ReadAll(body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		if c.maxBody > 0 && int64(len(payload)) > c.maxBody {
+			return nil, &LimitError{Limit: LimitPayloadSize, Max: c.maxBody}
+		}
+		if len(payload) > 0 {
+			msg.Payload = payload
+		}
+	}
+
+	if err := c.validate(msg); err != nil {
+		return nil, err
+	}
+
+	return &msg, nil
+}
+
+// validate checks msg with the validators, leaving out the Path and URL that
+// the standard validation does not allow for a SimpleRequestResponse.
+func (c *Converter) validate(msg wrp.Message) error {
+	msg.Path, msg.URL = "", ""
+	if err := msg.Validate(c.validators...); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	return nil
+}
+
+// WriteResponse writes the reply to a converted request to w.  The status code
+// is the Status of the reply, or http.StatusOK if it has no status that a
+// response with a body can be sent with, see MessageStatus.  The ContentType
+// becomes the Content-Type, the entries of Headers selected with
+// WithConvertedHeaders become response headers and the Payload is the body.
+// If the reply is invalid nothing is written.
+func (c *Converter) WriteResponse(w http.ResponseWriter, reply wrp.Union) error {
+	if isNil(reply) {
+		return fmt.Errorf("%w: message is nil", ErrInvalidMessage)
+	}
+
+	var msg wrp.Message
+	if err := reply.To(&msg, wrp.NoStandardValidation()); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+	if err := c.validate(msg); err != nil {
+		return err
+	}
+
+	headers := w.Header()
+	for _, entry := range msg.Headers {
+		name, value, ok := strings.Cut(entry, ":")
+		if !ok {
+			continue
+		}
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if c.headers[name] {
+			headers.Add(name, strings.TrimSpace(value))
+		}
+	}
+	if msg.ContentType != "" {
+		headers.Set("Content-Type", msg.ContentType)
+	}
+	headers.Set("Content-Length", strconv.Itoa(len(msg.Payload)))
+
+	status, ok := MessageStatus(&msg)
+	if !ok {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+
+	_, err := w.Write(msg.Payload)
+	return err
+}
+
+type converterOptionFunc func(*Converter) error
+
+func (f converterOptionFunc) apply(c *Converter) error {
+	return f(c)
+}
+
+// WithConvertedHeaders selects the HTTP headers that are carried in the
+// Headers of the message, in both directions.  Content-Type is always
+// converted using the ContentType of the message instead.
+func WithConvertedHeaders(names ...string) ConverterOption {
+	return converterOptionFunc(func(c *Converter) error {
+		for _, name := range names {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "" {
+				return errors.New("header name is empty")
+			}
+			if name != "Content-Type" {
+				c.headers[name] = true
+			}
+		}
+		return nil
+	})
+}
+
+// WithSource sets the Source of converted requests that do not specify one
+// with a source header.
+func WithSource(source string) ConverterOption {
+	return converterOptionFunc(func(c *Converter) error {
+		c.source = source
+		return nil
+	})
+}
+
+// WithMaxBodySize limits the size of the request body that is converted into
+// the Payload.  A value less than 1 means no limit.
+func WithMaxBodySize(n int64) ConverterOption {
+	return converterOptionFunc(func(c *Converter) error {
+		c.maxBody = n
+		return nil
+	})
+}
+
+// ConvertValidators sets the validators used for the converted messages and
+// the replies to them.  The standard WRP validators are used unless
+// wrp.NoStandardValidation() is provided.
+func ConvertValidators(v ...wrp.Processor) ConverterOption {
+	return converterOptionFunc(func(c *Converter) error {
+		c.validators = append(c.validators, v...)
+		return nil
+	})
+}
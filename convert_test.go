@@ -0,0 +1,211 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func TestConverterToMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []ConverterOption
+		method   string
+		target   string
+		header   http.Header
+		body     string
+		expected wrp.Message
+		err      error
+	}{
+		{
+			name:   "get",
+			method: http.MethodGet,
+			target: "http://example.com/api/v1/config?names=a,b",
+			header: http.Header{
+				"X-Webpa-Device-Name":      []string{"mac:112233445566/config"},
+				"X-Xmidt-Transaction-Uuid": []string{"uuid"},
+				"X-Xmidt-Partner-Id":       []string{"comcast, example"},
+				"Authorization":            []string{"Bearer token"},
+			},
+			opts: []ConverterOption{WithSource("dns:gateway.example.com")},
+			expected: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:gateway.example.com",
+				Destination:     "mac:112233445566/config",
+				TransactionUUID: "uuid",
+				PartnerIDs:      []string{"comcast", "example"},
+				Path:            http.MethodGet,
+				URL:             "/api/v1/config?names=a,b",
+			},
+		}, {
+			name:   "post with headers and body",
+			method: http.MethodPost,
+			target: "http://example.com/api/v1/config",
+			header: http.Header{
+				"Content-Type":             []string{"application/json"},
+				"X-Xmidt-Source":           []string{"dns:client.example.com"},
+				"X-Xmidt-Destination":      []string{"mac:112233445566/config"},
+				"X-Xmidt-Transaction-Uuid": []string{"uuid"},
+				"If-Match":                 []string{"etag"},
+				"Authorization":            []string{"Bearer token"},
+				"X-Trace":                  []string{"b", "a"},
+				"Accept-Language":          []string{"en"},
+				"Cache-Control":            []string{"no-cache"},
+			},
+			body: `{"a":1}`,
+			opts: []ConverterOption{
+				WithSource("dns:gateway.example.com"),
+				WithConvertedHeaders("x-trace", "if-match", "Content-Type", "Cache-Control", "Accept-Language"),
+			},
+			expected: wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:client.example.com",
+				Destination:     "mac:112233445566/config",
+				TransactionUUID: "uuid",
+				Path:            http.MethodPost,
+				URL:             "/api/v1/config",
+				ContentType:     "application/json",
+				Headers: []string{
+					"Accept-Language: en",
+					"Cache-Control: no-cache",
+					"If-Match: etag",
+					"X-Trace: b",
+					"X-Trace: a",
+				},
+				Payload: []byte(`{"a":1}`),
+			},
+		}, {
+			name:   "body too large",
+			method: http.MethodPost,
+			target: "http://example.com/",
+			header: http.Header{
+				"X-Xmidt-Source":           []string{"dns:client.example.com"},
+				"X-Xmidt-Destination":      []string{"mac:112233445566/config"},
+				"X-Xmidt-Transaction-Uuid": []string{"uuid"},
+			},
+			body: "0123456789",
+			opts: []ConverterOption{WithMaxBodySize(5)},
+			err:  ErrLimitExceeded,
+		}, {
+			name:   "no destination",
+			method: http.MethodGet,
+			target: "http://example.com/",
+			opts:   []ConverterOption{WithSource("dns:gateway.example.com")},
+			err:    ErrInvalidMessage,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := NewConverter(test.opts...)
+			require.NoError(t, err)
+
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+
+			got, err := c.ToMessage(req)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, *got)
+		})
+	}
+
+	t.Run("assigns a transaction uuid", func(t *testing.T) {
+		c, err := NewConverter(ConvertValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		a, err := c.ToMessage(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.NoError(t, err)
+		b, err := c.ToMessage(httptest.NewRequest(http.MethodGet, "/", nil))
+		require.NoError(t, err)
+		assert.NotEmpty(t, a.TransactionUUID)
+		assert.NotEqual(t, a.TransactionUUID, b.TransactionUUID)
+	})
+
+	t.Run("nil request", func(t *testing.T) {
+		c, err := NewConverter()
+		require.NoError(t, err)
+
+		_, err = c.ToMessage(nil)
+		require.ErrorIs(t, err, ErrNilRequest)
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewConverter(WithConvertedHeaders(" "))
+		require.Error(t, err)
+	})
+}
+
+func TestConverterWriteResponse(t *testing.T) {
+	c, err := NewConverter(
+		WithConvertedHeaders("ETag"),
+		ConvertValidators(wrp.NoStandardValidation()),
+	)
+	require.NoError(t, err)
+
+	t.Run("reply", func(t *testing.T) {
+		reply := wrp.Message{
+			Type:        wrp.SimpleRequestResponseMessageType,
+			ContentType: "application/json",
+			Headers:     []string{"etag: abc", "Set-Cookie: secret", "malformed"},
+			Payload:     []byte(`{"a":1}`),
+		}
+		reply.SetStatus(http.StatusCreated)
+
+		rec := httptest.NewRecorder()
+		require.NoError(t, c.WriteResponse(rec, &reply))
+
+		resp := rec.Result()
+		defer resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+		assert.Equal(t, "abc", resp.Header.Get("ETag"))
+		assert.Empty(t, resp.Header.Get("Set-Cookie"))
+		assert.Equal(t, "7", resp.Header.Get("Content-Length"))
+
+		body, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		assert.Equal(t, `{"a":1}`, string(body))
+	})
+
+	t.Run("no status", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, c.WriteResponse(rec, &wrp.Message{Type: wrp.SimpleRequestResponseMessageType}))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("status without a body", func(t *testing.T) {
+		for _, status := range []int64{http.StatusNoContent, http.StatusSwitchingProtocols} {
+			reply := wrp.Message{
+				Type:    wrp.SimpleRequestResponseMessageType,
+				Payload: []byte("reply"),
+			}
+			reply.SetStatus(status)
+
+			rec := httptest.NewRecorder()
+			require.NoError(t, c.WriteResponse(rec, &reply))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, "reply", rec.Body.String())
+		}
+	})
+
+	t.Run("nil reply", func(t *testing.T) {
+		var reply *wrp.Message
+		rec := httptest.NewRecorder()
+		require.ErrorIs(t, c.WriteResponse(rec, reply), ErrInvalidMessage)
+	})
+}
@@ -5,11 +5,11 @@ package wrphttp
 
 import (
 	"bufio"
-	"compress/flate"
-	"compress/gzip"
-	"compress/zlib"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"mime"
 	"mime/multipart"
 	"net/http"
@@ -19,205 +19,734 @@ import (
 	"github.com/xmidt-org/wrp-go/v5"
 )
 
+// Decoder contains the options used for decoding http.Request and
+// http.Response objects into wrp messages.  The Decoder is safe for concurrent
+// use once created.
+//
+// When the body, or a multipart part, has a Content-Digest header or trailer
+// the digest is checked once the body has been read.  A mismatch is reported
+// as a *DigestError after the messages, since they are decoded as the body is
+// read.
+type Decoder struct {
+	validators      []wrp.Processor
+	maxCompressed   int64
+	maxDecompressed int64
+	maxMessages     int
+	maxParts        int
+	maxLineLength   int
+	maxPayload      int
+	responseStatus  bool
+	secrets         [][]byte
+	lenient         bool
+}
+
+// DecoderOption is a functional option for configuring the Decoder.  The
+// options are applied in the order they are provided.
+type DecoderOption interface {
+	apply(*Decoder) error
+}
+
+// NewDecoder creates a new Decoder with the provided options.  The options are
+// applied in the order they are provided.  If no options are provided, no
+// limits are enforced other than the default JSONL line length of 64KiB.
+func NewDecoder(opts ...DecoderOption) (*Decoder, error) {
+	var decoder Decoder
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&decoder); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &decoder, nil
+}
+
 // DecodeRequest converts an http.Request into the provided wrp messages if
 // applicable.  This will handle any of the valid forms the encoder can produce.
 func DecodeRequest(req *http.Request, validators ...wrp.Processor) ([]wrp.Union, error) {
+	return (&Decoder{validators: validators}).DecodeRequest(req)
+}
+
+// DecodeRequestSeq returns an iterator that lazily converts an http.Request
+// into wrp messages.  Multipart parts, JSONL lines and MsgpackL items are
+// decoded one at a time as the iterator is advanced, so large batches can be
+// processed without holding every message in memory.  Iteration stops after
+// the first error is yielded.  The request body is closed when the iteration
+// completes or is stopped early.  The iterator may only be used once.
+func DecodeRequestSeq(req *http.Request, validators ...wrp.Processor) iter.Seq2[wrp.Union, error] {
+	return (&Decoder{validators: validators}).DecodeRequestSeq(req)
+}
+
+// DecodeResponse converts an http.Response into the provided wrp messages if
+// applicable.  This will handle any of the valid forms the encoder can produce.
+func DecodeResponse(resp *http.Response, validators ...wrp.Processor) ([]wrp.Union, error) {
+	return (&Decoder{validators: validators}).DecodeResponse(resp)
+}
+
+// DecodeResponseSeq returns an iterator that lazily converts an http.Response
+// into wrp messages.  See DecodeRequestSeq for the iteration semantics.
+func DecodeResponseSeq(resp *http.Response, validators ...wrp.Processor) iter.Seq2[wrp.Union, error] {
+	return (&Decoder{validators: validators}).DecodeResponseSeq(resp)
+}
+
+// DecodeFromParts converts an http.Header and io.ReadCloser into the provided wrp
+// messages if applicable.  This will handle any of the valid forms the encoder
+// can produce.
+func DecodeFromParts(headers http.Header, body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
+	return (&Decoder{validators: validators}).DecodeFromParts(headers, body)
+}
+
+// DecodeFromPartsSeq returns an iterator that lazily converts an http.Header
+// and io.ReadCloser into wrp messages.  See DecodeRequestSeq for the iteration
+// semantics.
+func DecodeFromPartsSeq(headers http.Header, body io.ReadCloser, validators ...wrp.Processor) iter.Seq2[wrp.Union, error] {
+	return (&Decoder{validators: validators}).DecodeFromPartsSeq(headers, body)
+}
+
+// DecodeRequest converts an http.Request into wrp messages, enforcing the
+// limits configured on the Decoder.
+func (d *Decoder) DecodeRequest(req *http.Request) ([]wrp.Union, error) {
+	return collect(d.DecodeRequestSeq(req))
+}
+
+// DecodeRequestSeq returns an iterator that lazily converts an http.Request
+// into wrp messages, enforcing the limits configured on the Decoder.  See the
+// package level DecodeRequestSeq for the iteration semantics.
+func (d *Decoder) DecodeRequestSeq(req *http.Request) iter.Seq2[wrp.Union, error] {
 	if req == nil {
-		return nil, fmt.Errorf("request is nil")
+		return func(yield func(wrp.Union, error) bool) {
+			yield(nil, ErrNilRequest)
+		}
 	}
 
-	ct, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
-	if err != nil {
-		return nil, err
+	return d.decodeSeq(req.Header, req.Body, func() http.Header { return req.Trailer })
+}
+
+// DecodeResponse converts an http.Response into wrp messages, enforcing the
+// limits configured on the Decoder.
+func (d *Decoder) DecodeResponse(resp *http.Response) ([]wrp.Union, error) {
+	return collect(d.DecodeResponseSeq(resp))
+}
+
+// DecodeResponseSeq returns an iterator that lazily converts an http.Response
+// into wrp messages, enforcing the limits configured on the Decoder.
+func (d *Decoder) DecodeResponseSeq(resp *http.Response) iter.Seq2[wrp.Union, error] {
+	if resp == nil {
+		return func(yield func(wrp.Union, error) bool) {
+			yield(nil, ErrNilResponse)
+		}
 	}
-	if !strings.HasPrefix(ct, "multipart/") {
-		return fromPart(req.Header, req.Body, validators...)
+
+	seq := d.decodeSeq(resp.Header, resp.Body, func() http.Header { return resp.Trailer })
+	if !d.responseStatus {
+		return seq
 	}
 
-	mr, err := req.MultipartReader()
-	if err != nil {
-		return nil, err
+	return func(yield func(wrp.Union, error) bool) {
+		for msg, err := range seq {
+			if m, ok := msg.(*wrp.Message); ok && m.Status == nil {
+				m.SetStatus(int64(resp.StatusCode))
+			}
+			if !yield(msg, err) {
+				return
+			}
+		}
 	}
+}
 
-	var rv []wrp.Union
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF { // nolint: errorlint
-			return rv, nil
+// DecodeFromParts converts an http.Header and io.ReadCloser into wrp messages,
+// enforcing the limits configured on the Decoder.
+func (d *Decoder) DecodeFromParts(headers http.Header, body io.ReadCloser) ([]wrp.Union, error) {
+	return collect(d.DecodeFromPartsSeq(headers, body))
+}
+
+// DecodeFromPartsSeq returns an iterator that lazily converts an http.Header
+// and io.ReadCloser into wrp messages, enforcing the limits configured on the
+// Decoder.
+func (d *Decoder) DecodeFromPartsSeq(headers http.Header, body io.ReadCloser) iter.Seq2[wrp.Union, error] {
+	return d.decodeSeq(headers, body, nil)
+}
+
+// decodeSeq decodes the body.  The trailer function returns the trailers once
+// the body has been read, if there can be any.
+func (d *Decoder) decodeSeq(headers http.Header, body io.ReadCloser, trailer func() http.Header) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		if body == nil {
+			body = http.NoBody
 		}
+
+		s := d.newState()
+		body = s.limitCompressed(body)
+
+		if len(s.secrets) > 0 {
+			var err error
+			body, err = s.verify(headers, trailer, body)
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		if dr := newDigestReader(headers, trailer, body, -1); dr != nil {
+			body = dr
+			yield = dr.guard(yield)
+			defer func() {
+				if dr.abandoned {
+					return
+				}
+				if err := dr.verify(); err != nil {
+					yield(nil, err)
+				}
+			}()
+		}
+
+		mediaType, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
 		if err != nil {
-			return nil, err
+			body.Close()
+			yield(nil, fmt.Errorf("%w: %w", ErrInvalidContentType, err))
+			return
+		}
+
+		yield = s.track(yield)
+
+		if !strings.HasPrefix(mediaType, "multipart/") {
+			s.fromPartSeq(headers, body, -1)(yield)
+			return
+		}
+
+		defer body.Close()
+
+		boundary := params["boundary"]
+		if boundary == "" {
+			yield(nil, fmt.Errorf("%w: missing boundary in %s", ErrInvalidContentType, headers.Get("Content-Type")))
+			return
+		}
+		if mediaType != "multipart/mixed" {
+			yield(nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mediaType))
+			return
 		}
 
-		msgs, err := fromPart(http.Header(part.Header), part, validators...)
+		envelope, err := s.decodeEnvelope(headers, body, boundary)
 		if err != nil {
-			return nil, err
+			yield(nil, err)
+			return
 		}
-		rv = append(rv, msgs...)
+		defer envelope.Close()
+
+		s.fromMultipartSeq(multipart.NewReader(envelope, boundary))(yield)
 	}
 }
 
-// DecodeResponse converts an http.Response into the provided wrp messages if
-// applicable.  This will handle any of the valid forms the encoder can produce.
-func DecodeResponse(resp *http.Response, validators ...wrp.Processor) ([]wrp.Union, error) {
-	if resp == nil {
-		return nil, fmt.Errorf("response is nil")
+// decodeEnvelope undoes any Content-Encoding applied to a whole multipart
+// body.  Earlier versions of the Encoder listed the coding of the parts in the
+// top level headers without compressing the envelope, so a body that already
+// starts with the boundary delimiter is used as is.  The returned reader does
+// not close body.
+func (s *decodeState) decodeEnvelope(h http.Header, body io.ReadCloser, boundary string) (io.ReadCloser, error) {
+	if len(parseCodings(h.Values("Content-Encoding"))) == 0 {
+		return body, nil
 	}
 
-	return DecodeFromParts(resp.Header, resp.Body, validators...)
-}
+	br := bufio.NewReader(body)
+	delim := "--" + boundary
+	if peek, _ := br.Peek(len(delim) + 2); bytes.Contains(peek, []byte(delim)) {
+		return io.NopCloser(br), nil
+	}
 
-// DecodeFromParts converts an http.Header and io.ReadCloser into the provided wrp
-// messages if applicable.  This will handle any of the valid forms the encoder
-// can produce.
-func DecodeFromParts(headers http.Header, body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	mediaType, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	rc, err := handleEncoding(h, io.NopCloser(br))
 	if err != nil {
-		body.Close()
-		return nil, fmt.Errorf("invalid Content-Type: %w", err)
+		return nil, s.decodeError(err, -1, h.Get("Content-Type"))
 	}
-	if !strings.HasPrefix(mediaType, "multipart/") {
-		return fromPart(headers, body, validators...)
+
+	// The envelope gets its own decompressed byte budget since the parts are
+	// accounted for individually.
+	if s.maxDecompressed > 0 {
+		remaining := s.maxDecompressed
+		rc = readCloser{
+			Reader: &limitedReader{
+				r:         rc,
+				remaining: &remaining,
+				err:       &LimitError{Limit: LimitDecompressedBytes, Max: s.maxDecompressed},
+				exceeded:  &s.exceeded,
+			},
+			Closer: rc,
+		}
 	}
 
-	defer body.Close()
+	return rc, nil
+}
 
-	boundary := params["boundary"]
-	if boundary == "" {
-		return nil, fmt.Errorf("missing boundary in Content-Type: %s", headers.Get("Content-Type"))
+// collect drains the iterator into a slice, returning the first error
+// encountered.  The failures skipped by lenient decoding are returned as a
+// *BatchError along with the messages that were decoded.
+func collect(seq iter.Seq2[wrp.Union, error]) ([]wrp.Union, error) {
+	var rv []wrp.Union
+	var skipped []*DecodeError
+	for msg, err := range seq {
+		if err != nil {
+			var de *DecodeError
+			if errors.As(err, &de) && de.skipped {
+				skipped = append(skipped, de)
+				continue
+			}
+			return nil, err
+		}
+		rv = append(rv, msg)
 	}
-	if mediaType != "multipart/mixed" {
-		return nil, fmt.Errorf("unsupported media type: %s", mediaType)
+	if len(skipped) > 0 {
+		return rv, &BatchError{Failures: skipped}
 	}
+	return rv, nil
+}
 
-	mr := multipart.NewReader(body, boundary)
+// decodeState holds the per call accounting used to enforce the Decoder
+// limits across every part of a body.
+type decodeState struct {
+	*Decoder
+	decompressed int64
+	messages     int
+	parts        int
 
-	var rv []wrp.Union
-	for {
-		part, err := mr.NextPart()
-		if err == io.EOF { // nolint: errorlint
-			return rv, nil
+	// exceeded records the first byte limit that was hit, since the format
+	// decoders do not always wrap the reader errors they encounter.
+	exceeded error
+}
+
+func (d *Decoder) newState() *decodeState {
+	return &decodeState{
+		Decoder:      d,
+		decompressed: d.maxDecompressed,
+	}
+}
+
+func (s *decodeState) limitCompressed(body io.ReadCloser) io.ReadCloser {
+	if s.maxCompressed <= 0 {
+		return body
+	}
+
+	remaining := s.maxCompressed
+	return readCloser{
+		Reader: &limitedReader{
+			r:         body,
+			remaining: &remaining,
+			err:       &LimitError{Limit: LimitCompressedBytes, Max: s.maxCompressed},
+			exceeded:  &s.exceeded,
+		},
+		Closer: body,
+	}
+}
+
+func (s *decodeState) limitDecompressed(body io.ReadCloser) io.ReadCloser {
+	if s.maxDecompressed <= 0 {
+		return body
+	}
+
+	return readCloser{
+		Reader: &limitedReader{
+			r:         body,
+			remaining: &s.decompressed,
+			err:       &LimitError{Limit: LimitDecompressedBytes, Max: s.maxDecompressed},
+			exceeded:  &s.exceeded,
+		},
+		Closer: body,
+	}
+}
+
+// track wraps yield to enforce the message limit and to report the limit that
+// caused a failure rather than the error the format decoder produced.
+func (s *decodeState) track(yield func(wrp.Union, error) bool) func(wrp.Union, error) bool {
+	return func(msg wrp.Union, err error) bool {
+		if err != nil {
+			var de *DecodeError
+			if !errors.As(err, &de) {
+				err = s.cause(err)
+			}
+		} else {
+			s.messages++
+			if s.maxMessages > 0 && s.messages > s.maxMessages {
+				yield(nil, &LimitError{Limit: LimitMessages, Max: int64(s.maxMessages)})
+				return false
+			}
+		}
+		return yield(msg, err)
+	}
+}
+
+func (s *decodeState) fromMultipartSeq(mr *multipart.Reader) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		for index := 0; ; index++ {
+			part, err := mr.NextPart()
+			if err == io.EOF { // nolint: errorlint
+				return
+			}
+			if err != nil {
+				yield(nil, s.decodeError(err, index, ""))
+				return
+			}
+
+			s.parts++
+			if s.maxParts > 0 && s.parts > s.maxParts {
+				part.Close()
+				yield(nil, &LimitError{Limit: LimitParts, Max: int64(s.maxParts)})
+				return
+			}
+
+			h := http.Header(part.Header)
+			body := io.ReadCloser(part)
+			dr := newDigestReader(h, nil, part, index)
+			if dr != nil {
+				body = dr
+			}
+
+			for msg, err := range s.fromPartSeq(h, body, index) {
+				skipped := err != nil && s.skip(err)
+				if !yield(msg, err) || (err != nil && !skipped) {
+					if dr != nil {
+						dr.abandoned = true
+					}
+					return
+				}
+			}
+
+			if dr != nil {
+				if err := dr.verify(); err != nil {
+					yield(nil, err)
+					return
+				}
+			}
 		}
+	}
+}
+
+// handleEncoding undoes the codings listed in the Content-Encoding header, in
+// the reverse of the order they were applied.
+func handleEncoding(h http.Header, body io.ReadCloser) (io.ReadCloser, error) {
+	names := parseCodings(h.Values("Content-Encoding"))
+
+	list := make([]ContentCoding, 0, len(names))
+	for _, name := range names {
+		c, err := lookupContentCoding(name)
 		if err != nil {
 			return nil, err
 		}
-		defer part.Close()
+		list = append(list, c)
+	}
+
+	c := newStackedCoding(list...)
+	if c.Name() == identityCoding {
+		return body, nil
+	}
+	return c.Decompress(body)
+}
 
-		msgs, err := fromPart(http.Header(part.Header), part, validators...)
+func (s *decodeState) fromPartSeq(h http.Header, body io.ReadCloser, part int) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		yield = s.located(yield, part, h.Get("Content-Type"))
+
+		if body != nil {
+			defer body.Close()
+		}
+
+		body, err := handleEncoding(h, body)
 		if err != nil {
-			return nil, err
+			yield(nil, err)
+			return
+		}
+		if body != nil {
+			defer body.Close()
+			body = s.limitDecompressed(body)
+		}
+
+		ct, err := toMediaTypeFromMime(h.Get("Content-Type"))
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		switch ct {
+		case mtJSON:
+			s.fromFormat(wrp.JSON, body)(yield)
+		case mtMsgpack:
+			s.fromFormat(wrp.Msgpack, body)(yield)
+		case mtOctetStream, mtOctetStreamXWebpa, mtOctetStreamXXmidt, mtOctetStreamXMidt, mtOctetStreamXmidt:
+			s.fromOctetStream(h, body)(yield)
+		case mtJSONL:
+			s.fromJSONL(body)(yield)
+		case mtMsgpackL:
+			s.fromMsgpackL(body)(yield)
+		default:
+			// Unreachable.
+			yield(nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, ct))
 		}
-		rv = append(rv, msgs...)
 	}
 }
 
-func handleEncoding(h http.Header, body io.ReadCloser) (io.ReadCloser, error) {
-	et := h.Get("Content-Encoding")
-	switch et {
-	case "gzip":
-		return gzip.NewReader(body)
-	case "deflate":
-		return flate.NewReader(body), nil
-	case "zlib":
-		return zlib.NewReader(body)
-	case "identity", "":
-		return body, nil
-	default:
+// checkPayload enforces the maximum payload size on a decoded message.
+func (s *decodeState) checkPayload(msg *wrp.Message) error {
+	if s.maxPayload > 0 && len(msg.Payload) > s.maxPayload {
+		return &LimitError{Limit: LimitPayloadSize, Max: int64(s.maxPayload)}
 	}
-	return nil, fmt.Errorf("unsupported content encoding: %s", et)
+	return nil
 }
 
-func fromPart(h http.Header, body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	var err error
+func (s *decodeState) fromFormat(f wrp.Format, body io.ReadCloser) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		var msg wrp.Message
+		err := f.Decoder(body).Decode(&msg, s.validators...)
+		if err == nil {
+			err = s.checkPayload(&msg)
+		}
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(&msg, nil)
+	}
+}
 
-	if body != nil {
-		defer body.Close()
+func (s *decodeState) fromOctetStream(h http.Header, body io.ReadCloser) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		if s.maxPayload > 0 && body != nil {
+			// The payload limit only concerns this message, so it is not
+			// recorded as the cause of later failures.
+			var exceeded error
+			remaining := int64(s.maxPayload)
+			body = readCloser{
+				Reader: &limitedReader{
+					r:         body,
+					remaining: &remaining,
+					err:       &LimitError{Limit: LimitPayloadSize, Max: int64(s.maxPayload)},
+					exceeded:  &exceeded,
+				},
+				Closer: body,
+			}
+		}
+
+		msg, err := fromHeaders(h, body, s.validators...)
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		yield(msg, nil)
 	}
+}
 
-	body, err = handleEncoding(h, body)
-	if err != nil {
-		return nil, err
+func (s *decodeState) fromJSONL(body io.ReadCloser) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		scanner := bufio.NewScanner(body)
+		maxLine := bufio.MaxScanTokenSize
+		if s.maxLineLength > 0 {
+			maxLine = s.maxLineLength
+			scanner.Buffer(make([]byte, 0, min(maxLine, bufio.MaxScanTokenSize)), maxLine)
+		}
+		var n int
+		for scanner.Scan() {
+			n++
+			var msg wrp.Message
+			line := scanner.Bytes()
+			err := wrp.JSON.DecoderBytes(line).Decode(&msg, s.validators...)
+			if err == nil {
+				err = s.checkPayload(&msg)
+			}
+			if err != nil {
+				de := &DecodeError{Item: n, Err: err}
+				if skipped := s.skip(de); !yield(nil, de) || !skipped {
+					return
+				}
+				continue
+			}
+			if !yield(&msg, nil) {
+				return
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			if errors.Is(err, bufio.ErrTooLong) {
+				err = &LimitError{Limit: LimitLineLength, Max: int64(maxLine)}
+			}
+			yield(nil, &DecodeError{Item: n + 1, Err: err})
+		}
 	}
-	if body != nil {
-		defer body.Close()
+}
+
+// fromMsgpackL decodes either the counted form (an array of msgpack bin
+// objects) or the uncounted form (consecutive msgpack bin objects until the
+// end of the body) produced when the encoder streams messages.
+func (s *decodeState) fromMsgpackL(body io.ReadCloser) iter.Seq2[wrp.Union, error] {
+	return func(yield func(wrp.Union, error) bool) {
+		r := msgp.NewReader(body)
+		typ, err := r.NextType()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		counted := typ != msgp.BinType
+		var count uint32
+		if counted {
+			count, err = r.ReadArrayHeader()
+			if err != nil {
+				yield(nil, err)
+				return
+			}
+		}
+
+		for i := uint32(0); !counted || i < count; i++ {
+			if !counted {
+				if _, err := r.NextType(); err == io.EOF { // nolint: errorlint
+					return
+				}
+			}
+
+			var msg wrp.Message
+			var item []byte
+			item, err = s.readMsgpackLItem(r)
+			if err != nil {
+				// The framing is lost, so the following items cannot be
+				// found either.
+				yield(nil, &DecodeError{Item: int(i) + 1, Err: err})
+				return
+			}
+
+			err = wrp.Msgpack.DecoderBytes(item).Decode(&msg, s.validators...)
+			if err == nil {
+				err = s.checkPayload(&msg)
+			}
+			if err != nil {
+				de := &DecodeError{Item: int(i) + 1, Err: err}
+				if skipped := s.skip(de); !yield(nil, de) || !skipped {
+					return
+				}
+				continue
+			}
+			if !yield(&msg, nil) {
+				return
+			}
+		}
 	}
+}
 
-	ct, err := toMediaTypeFromMime(h.Get("Content-Type"))
-	if err != nil {
-		return nil, err
+// readMsgpackLItem reads the next item, refusing to allocate more than the
+// remaining decompressed byte budget for it.
+func (s *decodeState) readMsgpackLItem(r *msgp.Reader) ([]byte, error) {
+	if s.maxDecompressed <= 0 {
+		return r.ReadBytes(nil)
 	}
 
-	switch ct {
-	case mtJSON:
-		return fromFormat(wrp.JSON, body, validators...)
-	case mtMsgpack:
-		return fromFormat(wrp.Msgpack, body, validators...)
-	case mtOctetStream, mtOctetStreamXWebpa, mtOctetStreamXXmidt, mtOctetStreamXMidt, mtOctetStreamXmidt:
-		return fromOctetStream(h, body, validators...)
-	case mtJSONL:
-		return fromJSONL(body, validators...)
-	case mtMsgpackL:
-		return fromMsgpackL(body, validators...)
+	item, err := r.ReadBytesLimit(nil, max(s.decompressed, 0))
+	if errors.Is(err, msgp.ErrLimitExceeded) {
+		return nil, &LimitError{Limit: LimitDecompressedBytes, Max: s.maxDecompressed}
 	}
+	return item, err
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
 
-	// Unreachable.
-	return nil, fmt.Errorf("unsupported media type: %s", ct)
+// limitedReader returns err once more than remaining bytes have been read.
+// Unlike io.LimitedReader the caller is told the limit was exceeded instead of
+// seeing a clean EOF.
+type limitedReader struct {
+	r         io.Reader
+	remaining *int64
+	err       error
+	exceeded  *error
 }
 
-func fromFormat(f wrp.Format, body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	var msg wrp.Message
-	if err := f.Decoder(body).Decode(&msg, validators...); err != nil {
-		return nil, err
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if *l.remaining < 0 {
+		return 0, l.trip()
+	}
+	if int64(len(p)) > *l.remaining+1 {
+		p = p[:*l.remaining+1]
 	}
-	return []wrp.Union{&msg}, nil
+
+	n, err := l.r.Read(p)
+	*l.remaining -= int64(n)
+	if *l.remaining < 0 {
+		return n, l.trip()
+	}
+	return n, err
 }
 
-func fromOctetStream(h http.Header, body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	msg, err := fromHeaders(h, body, validators...)
-	if err != nil {
-		return nil, err
+func (l *limitedReader) trip() error {
+	if *l.exceeded == nil {
+		*l.exceeded = l.err
 	}
-	return []wrp.Union{msg}, nil
+	return l.err
 }
 
-func fromJSONL(body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	var msgs []wrp.Union
-	scanner := bufio.NewScanner(body)
-	for scanner.Scan() {
-		var msg wrp.Message
-		line := scanner.Bytes()
-		if err := wrp.JSON.DecoderBytes(line).Decode(&msg, validators...); err != nil {
-			return nil, err
+// located wraps yield so every error is reported as a DecodeError describing
+// the part it came from.
+func (s *decodeState) located(yield func(wrp.Union, error) bool, part int, mt string) func(wrp.Union, error) bool {
+	return func(msg wrp.Union, err error) bool {
+		if err != nil {
+			err = s.decodeError(err, part, mt)
 		}
-		msgs = append(msgs, &msg)
+		return yield(msg, err)
 	}
-	if err := scanner.Err(); err != nil {
-		return nil, err
+}
+
+func (s *decodeState) decodeError(err error, part int, mt string) error {
+	de, ok := err.(*DecodeError) // nolint: errorlint
+	if !ok {
+		de = &DecodeError{Err: err}
 	}
+	de.Part = part
+	de.MediaType = mt
+	de.Err = s.classify(de.Err)
+	return de
+}
 
-	return msgs, nil
+// classify makes sure err matches one of the exported errors, treating
+// anything unrecognized as an invalid message.
+func (s *decodeState) classify(err error) error {
+	err = s.cause(err)
+	for _, known := range []error{
+		ErrLimitExceeded,
+		ErrUnsupportedEncoding,
+		ErrUnsupportedMediaType,
+		ErrInvalidContentType,
+		ErrInvalidMessage,
+	} {
+		if errors.Is(err, known) {
+			return err
+		}
+	}
+	return fmt.Errorf("%w: %w", ErrInvalidMessage, err)
 }
 
-func fromMsgpackL(body io.ReadCloser, validators ...wrp.Processor) ([]wrp.Union, error) {
-	var msgs []wrp.Union
-	r := msgp.NewReader(body)
-	count, err := r.ReadArrayHeader()
-	if err != nil {
-		return nil, err
+// skip reports whether lenient decoding can move on to the next message or
+// part after err, marking err as skipped if so.  Only failures confined to a
+// single JSONL line, MsgpackL item or multipart part are skipped.  Any other
+// limit being exceeded still ends decoding.
+func (s *decodeState) skip(err error) bool {
+	var de *DecodeError
+	if !s.lenient || s.exceeded != nil || !errors.As(err, &de) {
+		return false
 	}
-	var i uint32
-	for ; i < count; i++ {
-		var msg wrp.Message
-		var item []byte
-		item, err = r.ReadBytes(nil)
-		if err == nil {
-			err = wrp.Msgpack.DecoderBytes(item).Decode(&msg, validators...)
-		}
 
-		if err != nil {
-			return nil, err
-		}
-		msgs = append(msgs, &msg)
+	var le *LimitError
+	if errors.As(err, &le) && le.Limit != LimitPayloadSize {
+		return false
+	}
+
+	de.skipped = true
+	return true
+}
+
+// cause returns the LimitError responsible for err if there is one.
+func (s *decodeState) cause(err error) error {
+	if s.exceeded != nil {
+		return s.exceeded
+	}
+
+	var le *LimitError
+	if errors.As(err, &le) {
+		return le
 	}
-	return msgs, nil
+	return err
 }
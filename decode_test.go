@@ -4,6 +4,9 @@
 package wrphttp
 
 import (
+	"bytes"
+	"compress/gzip"
+	"errors"
 	"io"
 	"net/http"
 	"strings"
@@ -11,6 +14,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/tinylib/msgp/msgp"
 	"github.com/xmidt-org/wrp-go/v5"
 )
 
@@ -304,3 +308,466 @@ func TestFrom(t *testing.T) {
 		})
 	}
 }
+
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestDecodeSeq(t *testing.T) {
+	typs := []testOption{
+		{AsJSON(), "AsJSON"},
+		{AsJSONL(), "AsJSONL"},
+		{AsMsgpack(), "AsMsgpack"},
+		{AsMsgpackL(), "AsMsgpackL"},
+		{AsOctetStream(), "AsOctetStream"},
+	}
+
+	for _, typ := range typs {
+		t.Run(typ.name, func(t *testing.T) {
+			encoder, err := NewEncoder(typ.opt, EncodeGzip(), WithMaxItemsPerChunk(2),
+				EncodeValidators(wrp.NoStandardValidation()))
+			require.NoError(t, err)
+
+			headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+
+			var got []wrp.Union
+			for msg, err := range DecodeFromPartsSeq(headers, io.NopCloser(body), wrp.NoStandardValidation()) {
+				require.NoError(t, err)
+				got = append(got, msg)
+			}
+
+			require.Len(t, got, len(testWRPMessages))
+			for i := range testWRPMessages {
+				assert.Equal(t, testWRPMessages[i], *got[i].(*wrp.Message))
+			}
+		})
+	}
+
+	t.Run("stop early closes the body", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+
+		tracker := closeTracker{Reader: body}
+		resp := http.Response{
+			Header: headers,
+			Body:   &tracker,
+		}
+
+		var count int
+		for _, err := range DecodeResponseSeq(&resp, wrp.NoStandardValidation()) {
+			require.NoError(t, err)
+			count++
+			break
+		}
+
+		assert.Equal(t, 1, count)
+		assert.True(t, tracker.closed)
+	})
+
+	t.Run("errors are yielded", func(t *testing.T) {
+		req := http.Request{
+			Header: http.Header{
+				"Content-Type": []string{"application/jsonl"},
+			},
+			Body: io.NopCloser(strings.NewReader(`{"msg_type":4,"source":"source"}` + "\ninvalid\n")),
+		}
+
+		var msgs, errs int
+		for msg, err := range DecodeRequestSeq(&req, wrp.NoStandardValidation()) {
+			if err != nil {
+				errs++
+				assert.Nil(t, msg)
+				continue
+			}
+			msgs++
+		}
+
+		assert.Equal(t, 1, msgs)
+		assert.Equal(t, 1, errs)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		for msg, err := range DecodeRequestSeq(nil) {
+			require.Error(t, err)
+			assert.Nil(t, msg)
+		}
+		for msg, err := range DecodeResponseSeq(nil) {
+			require.Error(t, err)
+			assert.Nil(t, msg)
+		}
+	})
+}
+
+func TestDecodeEnvelope(t *testing.T) {
+	encoder, err := NewEncoder(AsMsgpack(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+	raw, err := io.ReadAll(body)
+	require.NoError(t, err)
+
+	t.Run("compressed by a proxy", func(t *testing.T) {
+		var buf bytes.Buffer
+		gw := gzip.NewWriter(&buf)
+		_, err := gw.Write(raw)
+		require.NoError(t, err)
+		require.NoError(t, gw.Close())
+
+		h := headers.Clone()
+		h.Set("Content-Encoding", "gzip")
+
+		got, err := DecodeFromParts(h, io.NopCloser(&buf), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		require.Len(t, got, len(testWRPMessages))
+		for i := range testWRPMessages {
+			assert.Equal(t, testWRPMessages[i], *got[i].(*wrp.Message))
+		}
+	})
+
+	t.Run("uncompressed envelope with a coding listed", func(t *testing.T) {
+		h := headers.Clone()
+		h.Set("Content-Encoding", "gzip")
+
+		got, err := DecodeFromParts(h, io.NopCloser(bytes.NewReader(raw)), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	})
+
+	t.Run("unsupported coding", func(t *testing.T) {
+		h := headers.Clone()
+		h.Set("Content-Encoding", "br")
+
+		got, err := DecodeFromParts(h, io.NopCloser(strings.NewReader("compressed")), wrp.NoStandardValidation())
+		require.ErrorIs(t, err, ErrUnsupportedEncoding)
+		assert.Nil(t, got)
+	})
+}
+
+func TestDecoderLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		encode []Option
+		opts   []DecoderOption
+		limit  string
+	}{
+		{
+			name:   "within limits",
+			encode: []Option{AsJSONL(), EncodeGzip()},
+			opts: []DecoderOption{
+				WithMaxCompressedBytes(1 << 20),
+				WithMaxDecompressedBytes(1 << 20),
+				WithMaxMessages(3),
+				WithMaxParts(1),
+				WithMaxLineLength(1024),
+				WithMaxPayloadSize(8),
+			},
+		}, {
+			name:   "too many messages",
+			encode: []Option{AsJSONL()},
+			opts:   []DecoderOption{WithMaxMessages(2)},
+			limit:  LimitMessages,
+		}, {
+			name:   "too many parts",
+			encode: []Option{AsMsgpack()},
+			opts:   []DecoderOption{WithMaxParts(2)},
+			limit:  LimitParts,
+		}, {
+			name:   "too many compressed bytes",
+			encode: []Option{AsMsgpackL(), EncodeGzip()},
+			opts:   []DecoderOption{WithMaxCompressedBytes(16)},
+			limit:  LimitCompressedBytes,
+		}, {
+			name:   "too many decompressed bytes",
+			encode: []Option{AsJSON(), EncodeGzip()},
+			opts:   []DecoderOption{WithMaxDecompressedBytes(64)},
+			limit:  LimitDecompressedBytes,
+		}, {
+			name:   "too many decompressed msgpackl bytes",
+			encode: []Option{AsMsgpackL(), EncodeZlib()},
+			opts:   []DecoderOption{WithMaxDecompressedBytes(64)},
+			limit:  LimitDecompressedBytes,
+		}, {
+			name:   "line too long",
+			encode: []Option{AsJSONL()},
+			opts:   []DecoderOption{WithMaxLineLength(32)},
+			limit:  LimitLineLength,
+		}, {
+			name:   "payload too large",
+			encode: []Option{AsMsgpackL()},
+			opts:   []DecoderOption{WithMaxPayloadSize(4)},
+			limit:  LimitPayloadSize,
+		}, {
+			name:   "octet-stream payload too large",
+			encode: []Option{AsOctetStream()},
+			opts:   []DecoderOption{WithMaxPayloadSize(4)},
+			limit:  LimitPayloadSize,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := NewEncoder(append(test.encode, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+
+			opts := append(test.opts, DecodeValidators(wrp.NoStandardValidation()))
+			decoder, err := NewDecoder(opts...)
+			require.NoError(t, err)
+
+			got, err := decoder.DecodeFromParts(headers, io.NopCloser(body))
+			if test.limit == "" {
+				require.NoError(t, err)
+				assert.Len(t, got, len(testWRPMessages))
+				return
+			}
+
+			require.ErrorIs(t, err, ErrLimitExceeded)
+			var le *LimitError
+			require.ErrorAs(t, err, &le)
+			assert.Equal(t, test.limit, le.Limit)
+			assert.Nil(t, got)
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		body   string
+		is     error
+		part   int
+		item   int
+	}{
+		{
+			name:   "missing content type",
+			header: http.Header{},
+			is:     ErrInvalidContentType,
+		}, {
+			name: "unsupported media type",
+			header: http.Header{
+				"Content-Type": []string{"image/png"},
+			},
+			is:   ErrUnsupportedMediaType,
+			part: -1,
+		}, {
+			name: "unsupported multipart",
+			header: http.Header{
+				"Content-Type": []string{"multipart/form-data; boundary=boundary"},
+			},
+			is: ErrUnsupportedMediaType,
+		}, {
+			name: "missing boundary",
+			header: http.Header{
+				"Content-Type": []string{"multipart/mixed"},
+			},
+			is: ErrInvalidContentType,
+		}, {
+			name: "unsupported encoding",
+			header: http.Header{
+				"Content-Type":     []string{"application/json"},
+				"Content-Encoding": []string{"br"},
+			},
+			is:   ErrUnsupportedEncoding,
+			part: -1,
+		}, {
+			name: "invalid jsonl line",
+			header: http.Header{
+				"Content-Type": []string{"application/jsonl"},
+			},
+			body: `{"msg_type":4,"source":"source"}` + "\n" +
+				`{"msg_type":4,"source":"source"}` + "\n" +
+				"invalid\n",
+			is:   ErrInvalidMessage,
+			part: -1,
+			item: 3,
+		}, {
+			name: "invalid second part",
+			header: http.Header{
+				"Content-Type": []string{"multipart/mixed; boundary=boundary"},
+			},
+			body: "--boundary\n" +
+				"Content-Type: application/json\n" +
+				"\n" +
+				"{\"msg_type\":3,\"source\":\"source\"}\n" +
+				"\n" +
+				"--boundary\n" +
+				"Content-Type: application/json\n" +
+				"\n" +
+				"invalid\n" +
+				"\n" +
+				"--boundary--\n",
+			is:   ErrInvalidMessage,
+			part: 1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := DecodeFromParts(test.header, io.NopCloser(strings.NewReader(test.body)), wrp.NoStandardValidation())
+			require.ErrorIs(t, err, test.is)
+			assert.Nil(t, got)
+
+			var de *DecodeError
+			if test.part == 0 && test.item == 0 {
+				assert.False(t, errors.As(err, &de))
+				return
+			}
+
+			require.ErrorAs(t, err, &de)
+			assert.Equal(t, test.part, de.Part)
+			assert.Equal(t, test.item, de.Item)
+		})
+	}
+}
+
+func TestLenientDecoding(t *testing.T) {
+	valid := `{"msg_type":4,"source":"source","payload":"cGF5bG9hZA=="}`
+
+	msgpackl := func(items ...[]byte) string {
+		b := msgp.AppendArrayHeader(nil, uint32(len(items))) // nolint: gosec
+		for _, item := range items {
+			b = msgp.AppendBytes(b, item)
+		}
+		return string(b)
+	}
+	event := wrp.MustEncode(&wrp.Message{Type: wrp.SimpleEventMessageType, Source: "source"}, wrp.Msgpack, wrp.NoStandardValidation())
+
+	type failure struct {
+		part, item int
+		is         error
+	}
+
+	tests := []struct {
+		name     string
+		ct       string
+		body     string
+		opts     []DecoderOption
+		count    int
+		failures []failure
+		fatal    error
+	}{
+		{
+			name:     "jsonl",
+			ct:       MEDIA_TYPE_JSONL,
+			body:     valid + "\ninvalid\n" + valid + "\n[1]\n" + valid + "\n",
+			count:    3,
+			failures: []failure{{-1, 2, ErrInvalidMessage}, {-1, 4, ErrInvalidMessage}},
+		}, {
+			name:     "every line fails",
+			ct:       MEDIA_TYPE_JSONL,
+			body:     "invalid\n",
+			failures: []failure{{-1, 1, ErrInvalidMessage}},
+		}, {
+			name:     "payload too large",
+			ct:       MEDIA_TYPE_JSONL,
+			body:     valid + "\n" + `{"msg_type":4,"source":"source"}` + "\n",
+			opts:     []DecoderOption{WithMaxPayloadSize(4)},
+			count:    1,
+			failures: []failure{{-1, 1, ErrLimitExceeded}},
+		}, {
+			name:     "msgpackl",
+			ct:       MEDIA_TYPE_MSGPACKL,
+			body:     msgpackl(event, []byte("junk"), event),
+			count:    2,
+			failures: []failure{{-1, 2, ErrInvalidMessage}},
+		}, {
+			name: "multipart",
+			ct:   "multipart/mixed; boundary=boundary",
+			body: "--boundary\r\nContent-Type: application/json\r\n\r\n" + valid + "\r\n" +
+				"--boundary\r\nContent-Type: application/json\r\n\r\ninvalid\r\n" +
+				"--boundary\r\nContent-Type: text/plain\r\n\r\nhello\r\n" +
+				"--boundary\r\nContent-Type: application/jsonl\r\n\r\n" + valid + "\ninvalid\n" + valid + "\r\n" +
+				"--boundary--\r\n",
+			count: 3,
+			failures: []failure{
+				{1, 0, ErrInvalidMessage},
+				{2, 0, ErrUnsupportedMediaType},
+				{3, 2, ErrInvalidMessage},
+			},
+		}, {
+			name:  "invalid single message",
+			ct:    MEDIA_TYPE_JSON,
+			body:  "invalid",
+			fatal: ErrInvalidMessage,
+		}, {
+			name:  "line too long",
+			ct:    MEDIA_TYPE_JSONL,
+			body:  "invalid\n" + valid + "\n",
+			opts:  []DecoderOption{WithMaxLineLength(32)},
+			fatal: ErrLimitExceeded,
+		}, {
+			name:  "too many messages",
+			ct:    MEDIA_TYPE_JSONL,
+			body:  "invalid\n" + valid + "\n" + valid + "\n",
+			opts:  []DecoderOption{WithMaxMessages(1)},
+			fatal: ErrLimitExceeded,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append(test.opts, LenientDecoding(), DecodeValidators(wrp.NoStandardValidation()))
+			decoder, err := NewDecoder(opts...)
+			require.NoError(t, err)
+
+			headers := http.Header{"Content-Type": []string{test.ct}}
+			got, err := decoder.DecodeFromParts(headers, io.NopCloser(strings.NewReader(test.body)))
+			if test.fatal != nil {
+				require.ErrorIs(t, err, test.fatal)
+				var be *BatchError
+				assert.False(t, errors.As(err, &be))
+				assert.Nil(t, got)
+				return
+			}
+
+			assert.Len(t, got, test.count)
+			var be *BatchError
+			require.ErrorAs(t, err, &be)
+			require.Len(t, be.Failures, len(test.failures))
+			for i, f := range test.failures {
+				assert.Equal(t, f.part, be.Failures[i].Part)
+				assert.Equal(t, f.item, be.Failures[i].Item)
+				assert.ErrorIs(t, be.Failures[i], f.is)
+			}
+
+			// The iterator reports each failure and carries on.
+			var msgs, errs int
+			for msg, err := range decoder.DecodeFromPartsSeq(headers, io.NopCloser(strings.NewReader(test.body))) {
+				if err != nil {
+					errs++
+					continue
+				}
+				assert.NotNil(t, msg)
+				msgs++
+			}
+			assert.Equal(t, test.count, msgs)
+			assert.Equal(t, len(test.failures), errs)
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		decoder, err := NewDecoder(LenientDecoding(false), DecodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		headers := http.Header{"Content-Type": []string{MEDIA_TYPE_JSONL}}
+		got, err := decoder.DecodeFromParts(headers, io.NopCloser(strings.NewReader(valid+"\ninvalid\n")))
+		var de *DecodeError
+		require.ErrorAs(t, err, &de)
+		assert.Equal(t, 2, de.Item)
+		assert.Nil(t, got)
+	})
+}
@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// ContentDigestHeader is the header that carries the digests of the content,
+// as described by RFC 9530.
+const ContentDigestHeader = "Content-Digest"
+
+// The digest algorithms that can be used with WithContentDigest and
+// WithPartDigests.
+const (
+	DigestSHA256 = "sha-256"
+	DigestSHA512 = "sha-512"
+)
+
+var digestHashes = map[string]func() hash.Hash{
+	DigestSHA256: sha256.New,
+	DigestSHA512: sha512.New,
+}
+
+// digestAlgorithms checks the algorithms, defaulting to DigestSHA256.
+func digestAlgorithms(algorithms []string) ([]string, error) {
+	if len(algorithms) == 0 {
+		return []string{DigestSHA256}, nil
+	}
+
+	rv := make([]string, 0, len(algorithms))
+	for _, alg := range algorithms {
+		alg = strings.ToLower(alg)
+		if _, ok := digestHashes[alg]; !ok {
+			return nil, fmt.Errorf("unsupported digest algorithm %q", alg)
+		}
+		rv = append(rv, alg)
+	}
+	return rv, nil
+}
+
+// digester computes the Content-Digest of the data written to it.
+type digester struct {
+	algs   []string
+	hashes []hash.Hash
+}
+
+func newDigester(algs []string) *digester {
+	d := digester{algs: algs}
+	for _, alg := range algs {
+		d.hashes = append(d.hashes, digestHashes[alg]())
+	}
+	return &d
+}
+
+func (d *digester) Write(p []byte) (int, error) {
+	for _, h := range d.hashes {
+		h.Write(p)
+	}
+	return len(p), nil
+}
+
+// String returns the Content-Digest header value, for example
+// "sha-256=:RK/0qy18MlBSVnWgjwz6lZEWjP/lF5HF9bvEF8FabDg=:".
+func (d *digester) String() string {
+	list := make([]string, 0, len(d.algs))
+	for i, alg := range d.algs {
+		list = append(list, alg+"=:"+base64.StdEncoding.EncodeToString(d.hashes[i].Sum(nil))+":")
+	}
+	return strings.Join(list, ", ")
+}
+
+// parseDigest returns the digests of the supported algorithms listed in the
+// Content-Digest header value.  Unsupported algorithms are ignored.
+func parseDigest(value string) map[string]string {
+	rv := make(map[string]string)
+	for member := range strings.SplitSeq(value, ",") {
+		alg, digest, ok := strings.Cut(strings.TrimSpace(member), "=")
+		if !ok {
+			continue
+		}
+		alg = strings.ToLower(strings.TrimSpace(alg))
+		if _, known := digestHashes[alg]; !known {
+			continue
+		}
+		// Drop any parameters.
+		digest, _, _ = strings.Cut(digest, ";")
+		rv[alg] = strings.TrimSpace(digest)
+	}
+	return rv
+}
+
+// writeDigestedPart writes a part whose body is buffered so its
+// Content-Digest can be sent in the part headers.
+func (e *Encoder) writeDigestedPart(mw *multipart.Writer, flush flusher, coding ContentCoding, fn bodyWriter, headers http.Header) error {
+	var buf bytes.Buffer
+	cw, err := coding.Compress(&buf)
+	if err != nil {
+		return err
+	}
+	if err = fn(cw, nil); err != nil {
+		cw.Close()
+		return err
+	}
+	if err = cw.Close(); err != nil {
+		return err
+	}
+
+	d := newDigester(e.partDigests)
+	d.Write(buf.Bytes())
+	headers.Set(ContentDigestHeader, d.String())
+
+	part, err := mw.CreatePart(textproto.MIMEHeader(headers))
+	if err != nil {
+		return err
+	}
+	if _, err = part.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	return flush.flush()
+}
+
+// digestReader hashes a body as it is decoded so its Content-Digest can be
+// checked once every message has been read.
+type digestReader struct {
+	io.ReadCloser
+	headers  http.Header
+	trailer  func() http.Header
+	part     int
+	digester *digester
+	eof      bool
+
+	// abandoned is set when decoding stops early or fails, in which case
+	// the rest of the body is not read and the digest is not checked.
+	abandoned bool
+}
+
+// newDigestReader returns a digestReader for body, or nil if there is no
+// Content-Digest to check, either in the headers or announced as a trailer.
+func newDigestReader(headers http.Header, trailer func() http.Header, body io.ReadCloser, part int) *digestReader {
+	var algs []string
+	if value := headers.Get(ContentDigestHeader); value != "" {
+		for alg := range parseDigest(value) {
+			algs = append(algs, alg)
+		}
+	} else if trailer != nil {
+		if _, ok := trailer()[ContentDigestHeader]; ok {
+			algs = []string{DigestSHA256, DigestSHA512}
+		}
+	}
+	if len(algs) == 0 {
+		return nil
+	}
+
+	return &digestReader{
+		ReadCloser: body,
+		headers:    headers,
+		trailer:    trailer,
+		part:       part,
+		digester:   newDigester(algs),
+	}
+}
+
+func (r *digestReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.digester.Write(p[:n])
+	if err == io.EOF { // nolint: errorlint
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close reads the rest of the body, which the format decoders may leave
+// unread, so the digest covers all of it.
+func (r *digestReader) Close() error {
+	if !r.abandoned && !r.eof {
+		_, _ = io.Copy(io.Discard, r)
+	}
+	return r.ReadCloser.Close()
+}
+
+// guard wraps yield to notice when decoding stops early or fails.  Failures
+// skipped by lenient decoding do not stop it, so the digest is still checked.
+func (r *digestReader) guard(yield func(wrp.Union, error) bool) func(wrp.Union, error) bool {
+	return func(msg wrp.Union, err error) bool {
+		var de *DecodeError
+		if err != nil && (!errors.As(err, &de) || !de.skipped) {
+			r.abandoned = true
+		}
+		if !yield(msg, err) {
+			r.abandoned = true
+			return false
+		}
+		return true
+	}
+}
+
+// verify compares the digest of the body with the Content-Digest.
+func (r *digestReader) verify() error {
+	if !r.eof {
+		if _, err := io.Copy(io.Discard, r); err != nil {
+			return err
+		}
+	}
+
+	value := r.headers.Get(ContentDigestHeader)
+	if value == "" && r.trailer != nil {
+		value = r.trailer().Get(ContentDigestHeader)
+	}
+	want := parseDigest(value)
+
+	for i, alg := range r.digester.algs {
+		expected, ok := want[alg]
+		if !ok {
+			continue
+		}
+		got := ":" + base64.StdEncoding.EncodeToString(r.digester.hashes[i].Sum(nil)) + ":"
+		if subtle.ConstantTimeCompare([]byte(got), []byte(expected)) != 1 {
+			return &DigestError{Algorithm: alg, Part: r.part}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,260 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func sha256Digest(body []byte) string {
+	sum := sha256.Sum256(body)
+	return "sha-256=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+}
+
+func TestWithContentDigest(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		expected func([]byte) string
+	}{
+		{
+			name:     "default",
+			opts:     []Option{WithContentDigest()},
+			expected: sha256Digest,
+		}, {
+			name: "sha-256 and sha-512 over the compressed body",
+			opts: []Option{WithContentDigest(DigestSHA256, "SHA-512"), EncodeGzip()},
+			expected: func(body []byte) string {
+				sum := sha512.Sum512(body)
+				return sha256Digest(body) + ", sha-512=:" + base64.StdEncoding.EncodeToString(sum[:]) + ":"
+			},
+		}, {
+			name:     "multipart",
+			opts:     []Option{AsJSON(), WithContentDigest()},
+			expected: sha256Digest,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := NewEncoder(append(test.opts, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			req, err := encoder.NewRequest(http.MethodPost, "http://example.com", toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected(body), req.Header.Get(ContentDigestHeader))
+
+			var buf bytes.Buffer
+			headers, err := encoder.EncodeTo(&buf, toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected(buf.Bytes()), headers.Get(ContentDigestHeader))
+
+			got, err := DecodeFromParts(req.Header, io.NopCloser(bytes.NewReader(body)), wrp.NoStandardValidation())
+			require.NoError(t, err)
+			assert.Len(t, got, len(testWRPMessages))
+
+			// Any change to the body is detected.
+			body[len(body)-1] ^= 0x01
+			_, err = DecodeFromParts(req.Header, io.NopCloser(bytes.NewReader(body)), wrp.NoStandardValidation())
+			require.Error(t, err)
+		})
+	}
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewEncoder(WithContentDigest("md5"))
+		require.Error(t, err)
+
+		_, err = NewEncoder(WithPartDigests("sha-1"))
+		require.Error(t, err)
+	})
+}
+
+func TestDigestMismatch(t *testing.T) {
+	body := []byte(`{"msg_type":4,"source":"mac:112233445566"}` + "\n")
+
+	tests := []struct {
+		name   string
+		digest string
+		err    bool
+	}{
+		{"match", sha256Digest(body), false},
+		{"match with unknown algorithms", "md5=:AAAA:, " + sha256Digest(body), false},
+		{"only unknown algorithms", "md5=:AAAA:", false},
+		{"mismatch", sha256Digest([]byte("other")), true},
+		{"malformed", "sha-256=AAAA", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := http.Header{
+				"Content-Type":      []string{MEDIA_TYPE_JSON},
+				ContentDigestHeader: []string{test.digest},
+			}
+
+			got, err := DecodeFromParts(headers, io.NopCloser(bytes.NewReader(body)), wrp.NoStandardValidation())
+			if !test.err {
+				require.NoError(t, err)
+				assert.Len(t, got, 1)
+				return
+			}
+
+			var de *DigestError
+			require.ErrorAs(t, err, &de)
+			assert.Equal(t, DigestSHA256, de.Algorithm)
+			assert.Equal(t, -1, de.Part)
+			assert.ErrorIs(t, err, ErrDigestMismatch)
+			assert.Equal(t, http.StatusBadRequest, StatusCode(err))
+		})
+	}
+}
+
+func TestDigestMismatchLenient(t *testing.T) {
+	valid := `{"msg_type":4,"source":"mac:112233445566"}`
+	body := []byte(valid + "\ninvalid\n" + valid + "\n")
+
+	decoder, err := NewDecoder(LenientDecoding(), DecodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		name   string
+		digest string
+		err    bool
+	}{
+		{"match", sha256Digest(body), false},
+		{"mismatch", sha256Digest([]byte("other")), true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			headers := http.Header{
+				"Content-Type":      []string{MEDIA_TYPE_JSONL},
+				ContentDigestHeader: []string{test.digest},
+			}
+
+			var count, skipped int
+			var digestErr error
+			for msg, err := range decoder.DecodeFromPartsSeq(headers, io.NopCloser(bytes.NewReader(body))) {
+				var de *DigestError
+				switch {
+				case errors.As(err, &de):
+					digestErr = err
+				case err != nil:
+					skipped++
+				case msg != nil:
+					count++
+				}
+			}
+
+			assert.Equal(t, 2, count)
+			assert.Equal(t, 1, skipped)
+			if test.err {
+				assert.ErrorIs(t, digestErr, ErrDigestMismatch)
+			} else {
+				assert.NoError(t, digestErr)
+			}
+		})
+	}
+}
+
+func TestWithPartDigests(t *testing.T) {
+	for _, opts := range [][]Option{
+		{AsJSON()},
+		{AsJSON(), EncodeGzip()},
+		{AsMsgpack(), EncodeGzip(), CompressEnvelope()},
+		{AsJSONL(), WithMaxItemsPerChunk(1)},
+	} {
+		encoder, err := NewEncoder(append(opts, WithPartDigests(), EncodeValidators(wrp.NoStandardValidation()))...)
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		data, err := io.ReadAll(body)
+		require.NoError(t, err)
+
+		got, err := DecodeFromParts(headers, io.NopCloser(bytes.NewReader(data)), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	}
+
+	// Replace the digest of the second part.
+	encoder, err := NewEncoder(AsJSON(), WithPartDigests(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+	headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+
+	_, params, err := mime.ParseMediaType(headers.Get("Content-Type"))
+	require.NoError(t, err)
+	mr := multipart.NewReader(body, params["boundary"])
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	require.NoError(t, mw.SetBoundary(params["boundary"]))
+	for i := 0; ; i++ {
+		part, err := mr.NextPart()
+		if err == io.EOF { // nolint: errorlint
+			break
+		}
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(part.Header.Get(ContentDigestHeader), "sha-256=:"))
+		if i == 1 {
+			part.Header.Set(ContentDigestHeader, sha256Digest([]byte("other")))
+		}
+		pw, err := mw.CreatePart(part.Header)
+		require.NoError(t, err)
+		_, err = io.Copy(pw, part)
+		require.NoError(t, err)
+	}
+	require.NoError(t, mw.Close())
+
+	_, err = DecodeFromParts(headers, io.NopCloser(&buf), wrp.NoStandardValidation())
+	var de *DigestError
+	require.ErrorAs(t, err, &de)
+	assert.Equal(t, 1, de.Part)
+}
+
+func TestContentDigestTrailer(t *testing.T) {
+	encoder, err := NewEncoder(AsJSONL(), WithContentDigest(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		assert.NoError(t, encoder.WriteResponse(w, http.StatusOK, toUnion(testWRPMessages)...))
+	}))
+	t.Cleanup(server.Close)
+
+	resp, err := http.Get(server.URL) // nolint: noctx
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Empty(t, resp.Header.Get(ContentDigestHeader))
+
+	got, err := DecodeResponse(resp, wrp.NoStandardValidation())
+	require.NoError(t, err)
+	assert.Len(t, got, len(testWRPMessages))
+	assert.NotEmpty(t, resp.Trailer.Get(ContentDigestHeader))
+
+	t.Run("mismatch", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		require.NoError(t, encoder.WriteResponse(rec, http.StatusOK, toUnion(testWRPMessages)...))
+		resp := rec.Result()
+		resp.Trailer.Set(ContentDigestHeader, sha256Digest([]byte("other")))
+
+		_, err := DecodeResponse(resp, wrp.NoStandardValidation())
+		require.ErrorIs(t, err, ErrDigestMismatch)
+	})
+}
@@ -106,6 +106,7 @@ func TestEncodeDecodeWRPMessages(t *testing.T) {
 		{EncodeGzip(), "EncodeGzip"},
 		{EncodeDeflate(), "EncodeDeflate"},
 		{EncodeZlib(), "EncodeZlib"},
+		{multiOption(EncodeGzip(), CompressEnvelope()), "EncodeGzip.CompressEnvelope"},
 	}
 
 	compat := []testOption{
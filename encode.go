@@ -6,29 +6,42 @@ package wrphttp
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
 	"maps"
 	"mime/multipart"
 	"net/http"
 	"net/textproto"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 
 	"github.com/tinylib/msgp/msgp"
 	"github.com/xmidt-org/wrp-go/v5"
 )
 
-type compressor func(io.Writer) (io.WriteCloser, error)
-
 // Encoder contains the options used for encoding new http.Request and http.Response
 // objects.  The Encoder is not safe for concurrent use.
 type Encoder struct {
 	mt                mediaType
 	compatibilityMode bool
-	compressor        compressor
-	encoding          string
+	coding            ContentCoding
+	envelope          bool
+	buffered          bool
+	eager             bool
 	validator         []wrp.Processor
 	style             string
 	maxItems          int
+	vary              []string
+	signer            *signer
+	digests           []string
+	partDigests       []string
+	canonical         bool
+	boundary          string
 }
 
 // Option is a functional option for configuring the Encoder.  The options are
@@ -78,245 +91,788 @@ func (e *Encoder) NewRequest(method, url string, msgs ...wrp.Union) (*http.Reque
 // NewRequestWithContext creates a new http.Request with the provided context
 // in addition to the method, URL, and messages.  The messages are encoded
 // using the Encoder's media type and compression.  The request is not sent
-// and the body is not closed.
+// and the body is not closed.  The encoding stops when ctx is done, see
+// ToPartsWithContext.  When the Encoder is Buffered the request has
+// its ContentLength and GetBody set, so it can be replayed on redirects and
+// retries.
 func (e *Encoder) NewRequestWithContext(ctx context.Context, method, url string, msgs ...wrp.Union) (*http.Request, error) {
-	h, body, err := e.ToParts(msgs...)
+	h, body, err := e.ToPartsWithContext(ctx, msgs...)
 	if err != nil {
 		return nil, err
 	}
 	// Construct the HTTP request with the pipe reader as the body
 	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
+		closeBody(body)
 		return nil, err
 	}
 
 	maps.Copy(req.Header, h)
+	// The request carries the length itself when the body is buffered.
+	req.Header.Del("Content-Length")
+	return req, nil
+}
+
+// closeBody stops the encoding of a body that will not be read.
+func closeBody(body io.Reader) {
+	if c, ok := body.(io.Closer); ok {
+		_ = c.Close()
+	}
+}
+
+// NewRequestSeq creates a new http.Request with the provided method, URL, and
+// the messages produced by the iterator.  See ToPartsSeq for how the messages
+// are encoded.  The request is not sent and the body is not closed.
+func (e *Encoder) NewRequestSeq(method, url string, msgs iter.Seq[wrp.Union]) (*http.Request, error) {
+	return e.NewRequestSeqWithContext(context.Background(), method, url, msgs)
+}
+
+// NewRequestSeqWithContext creates a new http.Request with the provided context
+// in addition to the method, URL, and the messages produced by the iterator.
+// See ToPartsSeq for how the messages are encoded.  The request is not sent
+// and the body is not closed.  The encoding stops when ctx is done, see
+// ToPartsWithContext.
+func (e *Encoder) NewRequestSeqWithContext(ctx context.Context, method, url string, msgs iter.Seq[wrp.Union]) (*http.Request, error) {
+	h, body, err := e.ToPartsSeqWithContext(ctx, msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		closeBody(body)
+		return nil, err
+	}
+
+	maps.Copy(req.Header, h)
+	// The request carries the length itself when the body is buffered.
+	req.Header.Del("Content-Length")
 	return req, nil
 }
 
 // ToParts encodes the provided messages into a mixed response body that can be
 // used in a http.Response.  The messages are encoded using the Encoder's
-// media type and compression.
+// media type and compression.  If the media type or compression was
+// negotiated, the Vary header lists the request headers that were used.
+//
+// By default the body is streamed from a goroutine as it is read, so most
+// validation failures are reported by reads of the body.  With
+// EagerValidation every message is validated before returning instead.  When
+// the Encoder is Buffered the body is fully encoded before returning, any
+// encoding error is returned directly and the Content-Length header is set.
 func (e *Encoder) ToParts(msgs ...wrp.Union) (http.Header, io.Reader, error) {
+	return e.ToPartsWithContext(context.Background(), msgs...)
+}
+
+// ToPartsWithContext works like ToParts, but stops encoding when ctx is done.
+// The body is encoded by a goroutine that blocks until the body is read.  If
+// the body is dropped without being read to the end, cancelling ctx makes the
+// goroutine exit and later reads of the body return the cause of ctx.
+func (e *Encoder) ToPartsWithContext(ctx context.Context, msgs ...wrp.Union) (http.Header, io.Reader, error) {
+	if ctx == nil {
+		return nil, nil, errNilContext
+	}
+
+	headers, write, err := e.prepare(msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.toReader(ctx, headers, write)
+}
+
+// ToPartsSeq encodes the messages produced by the iterator into a body that
+// can be used in a http.Request or http.Response.  Unlike ToParts, the
+// messages are pulled from the iterator as the body is read, so the producer
+// does not need to hold the whole batch in memory.
+//
+// Only the first two messages are examined before returning.  If the iterator
+// yields a single message the output is identical to ToParts.  Otherwise JSON,
+// Msgpack and octet-stream messages are written as multipart parts, JSONL and
+// MsgpackL messages are written as they arrive and split into multipart parts
+// according to WithMaxItemsPerChunk().  Since the number of messages is not
+// known up front, MsgpackL documents are written as a sequence of msgpack bin
+// objects without the leading array header.  The decoders in this package
+// accept both forms.  With EagerValidation only the examined messages are
+// validated before returning.
+func (e *Encoder) ToPartsSeq(msgs iter.Seq[wrp.Union]) (http.Header, io.Reader, error) {
+	return e.ToPartsSeqWithContext(context.Background(), msgs)
+}
+
+// ToPartsSeqWithContext works like ToPartsSeq, but stops encoding when ctx is
+// done.  See ToPartsWithContext.  The encoding goroutine can only notice ctx
+// between messages, so an iterator that blocks waiting for messages should
+// also stop when ctx is done.
+func (e *Encoder) ToPartsSeqWithContext(ctx context.Context, msgs iter.Seq[wrp.Union]) (http.Header, io.Reader, error) {
+	if ctx == nil {
+		return nil, nil, errNilContext
+	}
+
+	headers, write, err := e.prepareSeq(msgs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return e.toReader(ctx, headers, write)
+}
+
+// EncodeTo writes the encoded messages to w and returns the headers that
+// describe the body.  Unlike ToParts no goroutine or pipe is used.  If w
+// implements http.Flusher or has a Flush() error method, it is flushed after
+// each multipart part, JSONL line and MsgpackL item.
+func (e *Encoder) EncodeTo(w io.Writer, msgs ...wrp.Union) (http.Header, error) {
+	headers, write, err := e.prepare(msgs)
+	if err != nil {
+		return nil, err
+	}
+
+	flush := flusherFor(w)
+	hw, seal := e.newSeal()
+	if hw != nil {
+		w = io.MultiWriter(w, hw)
+	}
+
+	if err := write(w, flush); err != nil {
+		return nil, err
+	}
+
+	seal(headers)
+	return headers, nil
+}
+
+// WriteResponse writes the encoded messages to w as a response with the
+// provided status code.  The headers are set before the status code is
+// written and the body is written directly to w, flushing after each
+// multipart part, JSONL line and MsgpackL item so long streams reach the
+// client as they are produced.
+//
+// An error that occurs after the status code has been written matches
+// ErrPartialResponse.  Any other error means nothing was written, so a
+// different response can still be sent.
+func (e *Encoder) WriteResponse(w http.ResponseWriter, status int, msgs ...wrp.Union) error {
+	headers, write, err := e.prepare(msgs)
+	if err != nil {
+		return err
+	}
+
+	return e.writeResponse(w, status, headers, write)
+}
+
+// WriteStatusResponse works like WriteResponse, but the status code is chosen
+// from the Status of the messages by the policy.  A nil policy uses
+// FirstStatus.
+func (e *Encoder) WriteStatusResponse(w http.ResponseWriter, policy StatusPolicy, msgs ...wrp.Union) error {
+	if policy == nil {
+		policy = FirstStatus
+	}
+
+	return e.WriteResponse(w, policy(msgs), msgs...)
+}
+
+// WriteResponseSeq works like WriteResponse, but pulls the messages from the
+// iterator as they are written.  See ToPartsSeq for how the messages are
+// encoded.
+func (e *Encoder) WriteResponseSeq(w http.ResponseWriter, status int, msgs iter.Seq[wrp.Union]) error {
+	headers, write, err := e.prepareSeq(msgs)
+	if err != nil {
+		return err
+	}
+
+	return e.writeResponse(w, status, headers, write)
+}
+
+func (e *Encoder) writeResponse(w http.ResponseWriter, status int, headers http.Header, write bodyWriter) error {
+	if e.buffered {
+		var body io.Reader
+		var err error
+		headers, body, err = e.buffer(headers, write)
+		if err != nil {
+			return err
+		}
+		write = func(w io.Writer, _ flusher) error {
+			_, err := io.Copy(w, body)
+			return err
+		}
+	}
+
+	// The signature and digest of a streamed body are only known once it has
+	// been written, so they are sent as trailers.
+	var body io.Writer = w
+	seal := func(http.Header) {}
+	if !e.buffered {
+		var hw io.Writer
+		if hw, seal = e.newSeal(); hw != nil {
+			body = io.MultiWriter(w, hw)
+			for _, name := range e.sealNames() {
+				headers.Add("Trailer", name)
+			}
+		}
+	}
+
+	copyResponseHeaders(w.Header(), headers)
+	w.WriteHeader(status)
+
+	if err := write(body, flusherFor(w)); err != nil {
+		return fmt.Errorf("%w: %w", ErrPartialResponse, err)
+	}
+
+	seal(w.Header())
+	return nil
+}
+
+// copyResponseHeaders copies the headers of the body into the response
+// headers.  Vary is merged with any that is already set, for example by
+// middleware, rather than replacing it.
+func copyResponseHeaders(dst, src http.Header) {
+	for k, v := range src {
+		if k != "Vary" {
+			dst[k] = v
+			continue
+		}
+
+		var present []string
+		for _, value := range dst.Values("Vary") {
+			for name := range strings.SplitSeq(value, ",") {
+				present = append(present, strings.ToLower(strings.TrimSpace(name)))
+			}
+		}
+		for _, value := range v {
+			for name := range strings.SplitSeq(value, ",") {
+				name = strings.TrimSpace(name)
+				if name != "" && !slices.Contains(present, strings.ToLower(name)) {
+					dst.Add("Vary", name)
+					present = append(present, strings.ToLower(name))
+				}
+			}
+		}
+	}
+}
+
+// sealNames returns the headers that can only be set once the whole body is
+// known.
+func (e *Encoder) sealNames() []string {
+	var names []string
+	if e.signer != nil {
+		names = append(names, SignatureHeader)
+	}
+	if len(e.digests) > 0 {
+		names = append(names, ContentDigestHeader)
+	}
+	return names
+}
+
+// newSeal returns a writer that hashes the body and a function that sets
+// the signature and digest headers once the whole body has been written to
+// it.  The writer is nil if there is nothing to compute.
+func (e *Encoder) newSeal() (io.Writer, func(http.Header)) {
+	var writers []io.Writer
+	var seals []func(http.Header)
+
+	if e.signer != nil {
+		h := e.signer.newHash()
+		writers = append(writers, h)
+		seals = append(seals, func(headers http.Header) {
+			headers.Set(SignatureHeader, e.signer.format(h))
+		})
+	}
+	if len(e.digests) > 0 {
+		d := newDigester(e.digests)
+		writers = append(writers, d)
+		seals = append(seals, func(headers http.Header) {
+			headers.Set(ContentDigestHeader, d.String())
+		})
+	}
+
+	seal := func(headers http.Header) {
+		for _, fn := range seals {
+			fn(headers)
+		}
+	}
+	if len(writers) == 0 {
+		return nil, seal
+	}
+	return io.MultiWriter(writers...), seal
+}
+
+// prepare works out the headers for the messages and returns the function
+// that writes the body.
+func (e *Encoder) prepare(msgs []wrp.Union) (http.Header, bodyWriter, error) {
 	if len(msgs) == 0 {
-		return nil, nil, fmt.Errorf("no messages provided")
+		return nil, nil, ErrNoMessages
 	}
 
-	pr, pw := io.Pipe()
+	if e.eager {
+		validated, err := e.validateAll(msgs)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		// The messages have been validated, so skip validating them again
+		// while encoding.
+		v := *e
+		v.eager = false
+		v.validator = []wrp.Processor{wrp.NoStandardValidation()}
+		return v.prepare(validated)
+	}
 
 	var boundary string
+	var write bodyWriter
 	headers := e.getHeaders()
 
 	switch e.mt {
 	case mtJSON:
-		boundary = e.asFormat(wrp.JSON, pw, msgs...)
+		boundary, write = e.asFormat(wrp.JSON, msgs...)
 	case mtMsgpack:
-		boundary = e.asFormat(wrp.Msgpack, pw, msgs...)
+		boundary, write = e.asFormat(wrp.Msgpack, msgs...)
 	case mtOctetStream,
 		mtOctetStreamXXmidt, mtOctetStreamXMidt,
 		mtOctetStreamXWebpa, mtOctetStreamXmidt:
 		var err error
-		headers, boundary, err = e.asOctetStream(pw, msgs...)
+		headers, boundary, write, err = e.asOctetStream(msgs...)
 		if err != nil {
 			return nil, nil, err
 		}
 	case mtMsgpackL:
-		boundary = e.asMsgpackL(pw, msgs...)
+		boundary, write = e.asMsgpackL(msgs...)
 	case mtJSONL:
-		boundary = e.asJSONL(pw, msgs...)
+		boundary, write = e.asJSONL(msgs...)
 	default:
 		// Only reachable if there is a logic error in the code.
-		return nil, nil, fmt.Errorf("unsupported media type: %s", e.mt)
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, e.mt)
 	}
 
-	if boundary != "" {
-		headers.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", boundary))
-	}
+	e.finishHeaders(headers, boundary)
 
-	return headers, pr, nil
+	return headers, write, nil
 }
 
-func (e *Encoder) asFormat(f wrp.Format, pw *io.PipeWriter, msgs ...wrp.Union) string {
-	if len(msgs) == 1 {
-		e.asFormatSingle(f, pw, msgs...)
-		return ""
+// prepareSeq works like prepare for an iterator.  The returned function must
+// be called, since it releases the iterator.
+func (e *Encoder) prepareSeq(msgs iter.Seq[wrp.Union]) (http.Header, bodyWriter, error) {
+	if msgs == nil {
+		return nil, nil, ErrNoMessages
 	}
-	return e.asFormatMultiPart(f, pw, msgs...)
-}
 
-func (e *Encoder) asFormatSingle(f wrp.Format, pw *io.PipeWriter, msgs ...wrp.Union) {
-	go func() {
-		// Wrap the pipe writer with the compressor
-		cw, err := e.compressor(pw)
-		if err == nil {
-			err = f.Encoder(cw).Encode(msgs[0], e.validator...)
-			cw.Close()
+	next, stop := iter.Pull(msgs)
+	first, ok := next()
+	if !ok {
+		stop()
+		return nil, nil, ErrNoMessages
+	}
+	second, ok := next()
+	if !ok {
+		stop()
+		return e.prepare([]wrp.Union{first})
+	}
+
+	if e.eager {
+		if _, err := e.validateAll([]wrp.Union{first, second}); err != nil {
+			stop()
+			return nil, nil, err
 		}
+	}
 
-		if err != nil {
-			pw.CloseWithError(err)
+	rest := func(yield func(wrp.Union) bool) {
+		defer stop()
+		if !yield(first) || !yield(second) {
+			return
+		}
+		for {
+			msg, ok := next()
+			if !ok || !yield(msg) {
+				return
+			}
 		}
+	}
 
-		pw.Close()
-	}()
+	var boundary string
+	var write bodyWriter
+	headers := e.getHeaders()
+
+	switch e.mt {
+	case mtJSON:
+		boundary, write = e.asFormatMultiPart(wrp.JSON, rest)
+	case mtMsgpack:
+		boundary, write = e.asFormatMultiPart(wrp.Msgpack, rest)
+	case mtOctetStream,
+		mtOctetStreamXXmidt, mtOctetStreamXMidt,
+		mtOctetStreamXWebpa, mtOctetStreamXmidt:
+		boundary, write = e.asOctetStreamMultiPart(rest)
+	case mtMsgpackL:
+		boundary, write = e.asMsgpackLSeq(rest)
+	case mtJSONL:
+		boundary, write = e.asJSONLSeq(rest)
+	default:
+		// Only reachable if there is a logic error in the code.
+		stop()
+		return nil, nil, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, e.mt)
+	}
+
+	e.finishHeaders(headers, boundary)
+
+	return headers, write, nil
 }
 
-func (e *Encoder) asFormatMultiPart(f wrp.Format, pw *io.PipeWriter, msgs ...wrp.Union) string {
-	// Multiple messages: use multipart encoding
-	mw := multipart.NewWriter(pw)
+// toReader returns a reader for the body.  The body is written by a goroutine
+// as it is read, or up front when the Encoder is Buffered.
+func (e *Encoder) toReader(ctx context.Context, headers http.Header, write bodyWriter) (http.Header, io.Reader, error) {
+	// The returned headers cannot change once the body is read, so a signed
+	// or digested body is always buffered.
+	if e.buffered || len(e.sealNames()) > 0 {
+		return e.buffer(headers, write)
+	}
 
+	pr, pw := newPipe(ctx)
 	go func() {
-		defer func() {
-			if err := mw.Close(); err != nil {
-				pw.CloseWithError(err)
-			} else {
-				pw.Close()
-			}
-		}()
+		pw.CloseWithError(write(pw, nil))
+	}()
+
+	return headers, pr, nil
+}
 
-		header := textproto.MIMEHeader(e.getHeaders())
+var errNilContext = errors.New("context is nil")
 
-		for _, msg := range msgs {
-			part, err := mw.CreatePart(header)
-			if err != nil {
-				pw.CloseWithError(err)
+// pipeWriter is the write side of a body.  The pipe is closed with the cause
+// of the context when the context is done, which unblocks the encoding
+// goroutine.  Closing the writer releases the context.
+type pipeWriter struct {
+	*io.PipeWriter
+	release func() bool
+}
+
+func newPipe(ctx context.Context) (*io.PipeReader, *pipeWriter) {
+	pr, pw := io.Pipe()
+	release := context.AfterFunc(ctx, func() {
+		// Closing the write side unblocks a pending write and is reported
+		// to the reader, unlike closing the read side.
+		pw.CloseWithError(context.Cause(ctx))
+	})
+
+	return pr, &pipeWriter{
+		PipeWriter: pw,
+		release:    release,
+	}
+}
+
+func (p *pipeWriter) Close() error {
+	return p.CloseWithError(nil)
+}
+
+func (p *pipeWriter) CloseWithError(err error) error {
+	p.release()
+	return p.PipeWriter.CloseWithError(err)
+}
+
+var bufferPool = sync.Pool{
+	New: func() any {
+		return new(bytes.Buffer)
+	},
+}
+
+// buffer writes the whole body so it can be sent with a known length.  A
+// pooled buffer is used while encoding and the result is copied into a slice
+// of the exact size, since the slice outlives the call.
+func (e *Encoder) buffer(headers http.Header, write bodyWriter) (http.Header, io.Reader, error) {
+	buf := bufferPool.Get().(*bytes.Buffer) // nolint: forcetypeassert
+	defer func() {
+		buf.Reset()
+		bufferPool.Put(buf)
+	}()
+
+	if err := write(buf, nil); err != nil {
+		return nil, nil, err
+	}
+
+	headers.Set("Content-Length", strconv.Itoa(buf.Len()))
+	if hw, seal := e.newSeal(); hw != nil {
+		_, _ = hw.Write(buf.Bytes())
+		seal(headers)
+	}
+	return headers, bytes.NewReader(bytes.Clone(buf.Bytes())), nil
+}
+
+// FromChan adapts a channel of messages into an iterator that can be passed to
+// ToPartsSeq or NewRequestSeq.  The iterator completes when the channel is
+// closed.
+func FromChan(ch <-chan wrp.Union) iter.Seq[wrp.Union] {
+	return func(yield func(wrp.Union) bool) {
+		for msg := range ch {
+			if !yield(msg) {
 				return
 			}
+		}
+	}
+}
 
-			// Wrap the pipe writer with the compressor
-			cw, err := e.compressor(part)
-			if err == nil {
-				err = f.Encoder(cw).Encode(msg, e.validator...)
-				cw.Close()
+// bodyWriter writes an encoded body to w.  The flusher is called after each
+// multipart part, JSONL line and MsgpackL item.
+type bodyWriter func(w io.Writer, flush flusher) error
+
+// flusher pushes the data written so far through to the client.  A nil
+// flusher does nothing and also leaves any intermediate buffers alone, which
+// keeps compression effective when nobody is waiting for the data.
+type flusher func() error
+
+func (f flusher) flush() error {
+	if f == nil {
+		return nil
+	}
+	return f()
+}
+
+// through returns a flusher that flushes w, if it buffers data, before
+// calling f.
+func (f flusher) through(w io.Writer) flusher {
+	if f == nil {
+		return nil
+	}
+
+	fw, ok := w.(interface{ Flush() error })
+	if !ok {
+		return f
+	}
+
+	return func() error {
+		if err := fw.Flush(); err != nil {
+			return err
+		}
+		return f()
+	}
+}
+
+// flusherFor returns the flusher for w, or nil if w cannot be flushed.
+func flusherFor(w io.Writer) flusher {
+	switch f := w.(type) {
+	case http.ResponseWriter:
+		rc := http.NewResponseController(f)
+		return func() error {
+			if err := rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+				return err
 			}
+			return nil
+		}
+	case http.Flusher:
+		return func() error {
+			f.Flush()
+			return nil
+		}
+	case interface{ Flush() error }:
+		return f.Flush
+	}
+
+	return nil
+}
+
+func (e *Encoder) asFormat(f wrp.Format, msgs ...wrp.Union) (string, bodyWriter) {
+	if len(msgs) == 1 {
+		return "", e.asSingle(func(w io.Writer, _ flusher) error {
+			return e.encode(f, w, msgs[0])
+		})
+	}
+	return e.asFormatMultiPart(f, slices.Values(msgs))
+}
+
+// asSingle returns the writer for a single, non-multipart body written by fn.
+func (e *Encoder) asSingle(fn bodyWriter) bodyWriter {
+	return func(w io.Writer, flush flusher) error {
+		// Wrap the writer with the compressor
+		cw, err := e.coding.Compress(w)
+		if err != nil {
+			return err
+		}
 
+		if err := fn(cw, flush.through(cw)); err != nil {
+			cw.Close()
+			return err
+		}
+		return cw.Close()
+	}
+}
+
+func (e *Encoder) asFormatMultiPart(f wrp.Format, msgs iter.Seq[wrp.Union]) (string, bodyWriter) {
+	return e.asMultipart(func(mw *multipart.Writer, flush flusher) error {
+		for msg := range msgs {
+			err := e.writePart(mw, nil, func(w io.Writer, _ flusher) error {
+				return e.encode(f, w, msg)
+			})
+			if err == nil {
+				err = flush.flush()
+			}
 			if err != nil {
-				pw.CloseWithError(err)
-				return
+				return err
 			}
 		}
-	}()
+		return nil
+	})
+}
+
+// asMultipart returns the boundary and the writer for a multipart/mixed body
+// whose parts are created by fn.  When the envelope is compressed the whole
+// body is passed through the compressor instead of each part.
+func (e *Encoder) asMultipart(fn func(*multipart.Writer, flusher) error) (string, bodyWriter) {
+	if e.canonical && e.boundary == "" {
+		return e.asCanonicalMultipart(fn)
+	}
 
-	return mw.Boundary()
+	boundary := e.newBoundary()
+	return boundary, func(w io.Writer, flush flusher) error {
+		return e.writeMultipart(w, flush, boundary, fn)
+	}
 }
 
-func (e *Encoder) asOctetStream(pw *io.PipeWriter, msgs ...wrp.Union) (http.Header, string, error) {
-	if len(msgs) == 1 {
-		h, err := e.asOctetStreamSingle(pw, msgs[0])
+// writeMultipart writes the multipart body with the parts created by fn.
+func (e *Encoder) writeMultipart(w io.Writer, flush flusher, boundary string, fn func(*multipart.Writer, flusher) error) error {
+	cw := io.WriteCloser(nopWriteCloser{Writer: w})
+	if e.envelope {
+		var err error
+		cw, err = e.coding.Compress(w)
 		if err != nil {
-			return nil, "", err
+			return err
 		}
-		return h, "", nil
 	}
 
-	return e.asOctetStreamMultiPart(pw, msgs...)
+	mw := multipart.NewWriter(cw)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	if err := fn(mw, flush.through(cw)); err != nil {
+		return err
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	return cw.Close()
 }
 
-func (e *Encoder) asOctetStreamSingle(pw *io.PipeWriter, msgs ...wrp.Union) (http.Header, error) {
-	headers, payload, err := toHeadersForm(msgs[0], e.style, e.validator...)
+// writePart creates a part with the optional headers and writes its body
+// using fn.  The part is compressed unless the envelope is compressed.  With
+// WithPartDigests the part is buffered so its digest can be sent first.
+func (e *Encoder) writePart(mw *multipart.Writer, flush flusher, fn bodyWriter, h ...http.Header) error {
+	coding := e.coding
+	if e.envelope {
+		coding = identity()
+	}
+
+	if len(e.partDigests) > 0 {
+		return e.writeDigestedPart(mw, flush, coding, fn, e.partHeaders(h...))
+	}
+
+	part, err := mw.CreatePart(textproto.MIMEHeader(e.partHeaders(h...)))
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	go func() {
-		// Wrap the pipe writer with the compressor
-		cw, err := e.compressor(pw)
-		if err == nil {
-			_, err = cw.Write(payload)
-			cw.Close()
-		}
+	cw, err := coding.Compress(part)
+	if err != nil {
+		return err
+	}
 
+	if err = fn(cw, flush.through(cw)); err != nil {
+		cw.Close()
+		return err
+	}
+	return cw.Close()
+}
+
+func (e *Encoder) asOctetStream(msgs ...wrp.Union) (http.Header, string, bodyWriter, error) {
+	if len(msgs) == 1 {
+		h, write, err := e.asOctetStreamSingle(msgs[0])
 		if err != nil {
-			pw.CloseWithError(err)
-			return
+			return nil, "", nil, err
 		}
+		return h, "", write, nil
+	}
 
-		pw.Close()
-	}()
-
-	return e.getHeaders(headers), nil
+	boundary, write := e.asOctetStreamMultiPart(slices.Values(msgs))
+	return e.getHeaders(), boundary, write, nil
 }
 
-func (e *Encoder) asOctetStreamMultiPart(pw *io.PipeWriter, msgs ...wrp.Union) (http.Header, string, error) {
-	// Multiple messages: use multipart encoding
-	mw := multipart.NewWriter(pw)
+func (e *Encoder) asOctetStreamSingle(msg wrp.Union) (http.Header, bodyWriter, error) {
+	headers, payload, err := toHeadersForm(msg, e.style, e.canonical, e.validator...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	go func() {
-		defer func() {
-			if err := mw.Close(); err != nil {
-				pw.CloseWithError(err)
-			} else {
-				pw.Close()
-			}
-		}()
+	write := e.asSingle(func(w io.Writer, _ flusher) error {
+		_, err := w.Write(payload)
+		return err
+	})
+
+	return e.getHeaders(headers), write, nil
+}
 
-		for _, msg := range msgs {
-			headers, payload, err := toHeadersForm(msg, e.style, e.validator...)
+func (e *Encoder) asOctetStreamMultiPart(msgs iter.Seq[wrp.Union]) (string, bodyWriter) {
+	return e.asMultipart(func(mw *multipart.Writer, flush flusher) error {
+		for msg := range msgs {
+			headers, payload, err := toHeadersForm(msg, e.style, e.canonical, e.validator...)
 			if err == nil {
-				var part io.Writer
-				headers = e.getHeaders(headers)
-				part, err = mw.CreatePart(textproto.MIMEHeader(headers))
-				if err == nil {
-					var cw io.WriteCloser
-					// Wrap the pipe writer with the compressor
-					cw, err = e.compressor(part)
-					if err == nil {
-						_, err = cw.Write(payload)
-						cw.Close()
-					}
-				}
+				err = e.writePart(mw, nil, func(w io.Writer, _ flusher) error {
+					_, err := w.Write(payload)
+					return err
+				}, headers)
+			}
+			if err == nil {
+				err = flush.flush()
 			}
-
 			if err != nil {
-				pw.CloseWithError(err)
-				return
+				return err
 			}
 		}
-	}()
-
-	return e.getHeaders(), mw.Boundary(), nil
+		return nil
+	})
 }
 
-func (e *Encoder) asMsgpackL(pw *io.PipeWriter, msgs ...wrp.Union) string {
+func (e *Encoder) asMsgpackL(msgs ...wrp.Union) (string, bodyWriter) {
 	if e.maxItems < 1 || len(msgs) <= e.maxItems {
-		e.asMsgpackLSingle(pw, msgs...)
-		return ""
+		return "", e.asSingle(func(w io.Writer, flush flusher) error {
+			return e.asMsgpackLArray(w, flush, msgs...)
+		})
 	}
-	return e.chunkedMultipart(pw,
-		func(w io.Writer, msgs []wrp.Union) error {
-			return e.asMsgpackLArray(w, msgs...)
+	return e.chunkedMultipart(
+		func(w io.Writer, flush flusher, msgs iter.Seq[wrp.Union]) error {
+			return e.asMsgpackLArray(w, flush, slices.Collect(msgs)...)
 		},
-		msgs...)
+		slices.Values(msgs))
 }
 
-func (e *Encoder) asMsgpackLSingle(pw *io.PipeWriter, msgs ...wrp.Union) {
-	go func() {
-		// Wrap the pipe writer with the compressor
-		cw, err := e.compressor(pw)
-		if err == nil {
-			err = e.asMsgpackLArray(cw, msgs...)
-			cw.Close()
-		}
-		if err != nil {
-			pw.CloseWithError(err)
-		}
-
-		pw.Close()
-	}()
+// asMsgpackLSeq writes the messages using the uncounted MsgpackL framing since
+// the number of messages is not known ahead of time.
+func (e *Encoder) asMsgpackLSeq(msgs iter.Seq[wrp.Union]) (string, bodyWriter) {
+	if e.maxItems < 1 {
+		return "", e.asSingle(func(w io.Writer, flush flusher) error {
+			return e.asMsgpackLStream(w, flush, msgs)
+		})
+	}
+	return e.chunkedMultipart(e.asMsgpackLStream, msgs)
 }
 
-func (e *Encoder) asMsgpackLArray(w io.Writer, msgs ...wrp.Union) error {
+func (e *Encoder) asMsgpackLArray(w io.Writer, flush flusher, msgs ...wrp.Union) error {
 	wr := msgp.NewWriter(w)
 	if err := wr.WriteArrayHeader(uint32(len(msgs))); err != nil { // nolint: gosec
 		return err
 	}
 
-	for _, msg := range msgs {
+	return e.asMsgpackLItems(wr, flush, slices.Values(msgs))
+}
+
+// asMsgpackLStream writes the messages as consecutive msgpack bin objects
+// without the leading array header.
+func (e *Encoder) asMsgpackLStream(w io.Writer, flush flusher, msgs iter.Seq[wrp.Union]) error {
+	return e.asMsgpackLItems(msgp.NewWriter(w), flush, msgs)
+}
+
+func (e *Encoder) asMsgpackLItems(wr *msgp.Writer, flush flusher, msgs iter.Seq[wrp.Union]) error {
+	flush = flush.through(wr)
+	for msg := range msgs {
 		var item bytes.Buffer
-		err := wrp.Msgpack.Encoder(&item).Encode(msg, e.validator...)
+		err := e.encode(wrp.Msgpack, &item, msg)
 		if err == nil {
 			err = wr.WriteBytes(item.Bytes())
 		}
+		if err == nil {
+			err = flush.flush()
+		}
 
 		if err != nil {
 			return err
@@ -328,64 +884,56 @@ func (e *Encoder) asMsgpackLArray(w io.Writer, msgs ...wrp.Union) error {
 	return nil
 }
 
-type encoderPartFunc func(w io.Writer, msgs []wrp.Union) error
-
-func (e *Encoder) chunkedMultipart(pw *io.PipeWriter, fn encoderPartFunc, msgs ...wrp.Union) string {
-	// Multiple messages: use multipart encoding
-	mw := multipart.NewWriter(pw)
-	go func() {
-		defer func() {
-			if err := mw.Close(); err != nil {
-				pw.CloseWithError(err)
-			} else {
-				pw.Close()
-			}
-		}()
-		header := textproto.MIMEHeader(e.getHeaders())
+// encoderPartFunc writes the messages of a chunk.
+type encoderPartFunc func(w io.Writer, flush flusher, msgs iter.Seq[wrp.Union]) error
 
-		items := chunked{
-			list:     msgs,
-			perChunk: e.maxItems,
-		}
+func (e *Encoder) chunkedMultipart(fn encoderPartFunc, msgs iter.Seq[wrp.Union]) (string, bodyWriter) {
+	return e.asMultipart(func(mw *multipart.Writer, flush flusher) error {
+		items := newChunked(msgs, e.maxItems)
+		defer items.Stop()
 		for {
 			msgs := items.Next()
 			if msgs == nil {
-				return
+				return nil
 			}
 
-			part, err := mw.CreatePart(header)
+			err := e.writePart(mw, flush, func(w io.Writer, flush flusher) error {
+				return fn(w, flush, msgs)
+			})
 			if err == nil {
-				var cw io.WriteCloser
-				cw, err = e.compressor(part)
-				if err == nil {
-					err = fn(cw, msgs)
-					cw.Close()
-				}
+				err = flush.flush()
 			}
 			if err != nil {
-				pw.CloseWithError(err)
-				return
+				return err
 			}
 		}
-	}()
-
-	return mw.Boundary()
+	})
 }
 
-func (e *Encoder) asJSONL(pw *io.PipeWriter, msgs ...wrp.Union) string {
+func (e *Encoder) asJSONL(msgs ...wrp.Union) (string, bodyWriter) {
 	if e.maxItems < 1 || len(msgs) <= e.maxItems {
-		e.asJSONLSingle(pw, msgs...)
-		return ""
+		return "", e.asSingle(func(w io.Writer, flush flusher) error {
+			return e.asJSONLArray(w, flush, slices.Values(msgs))
+		})
+	}
+	return e.chunkedMultipart(e.asJSONLArray, slices.Values(msgs))
+}
+
+func (e *Encoder) asJSONLSeq(msgs iter.Seq[wrp.Union]) (string, bodyWriter) {
+	if e.maxItems < 1 {
+		return "", e.asSingle(func(w io.Writer, flush flusher) error {
+			return e.asJSONLArray(w, flush, msgs)
+		})
 	}
-	return e.chunkedMultipart(pw,
-		func(w io.Writer, msgs []wrp.Union) error {
-			return e.asJSONLArray(w, msgs...)
-		}, msgs...)
+	return e.chunkedMultipart(e.asJSONLArray, msgs)
 }
 
-func (e *Encoder) asJSONLArray(w io.Writer, msgs ...wrp.Union) error {
-	for _, msg := range msgs {
-		if err := wrp.JSON.Encoder(w).Encode(msg, e.validator...); err != nil {
+func (e *Encoder) asJSONLArray(w io.Writer, flush flusher, msgs iter.Seq[wrp.Union]) error {
+	for msg := range msgs {
+		if err := e.encode(wrp.JSON, w, msg); err != nil {
+			return err
+		}
+		if err := flush.flush(); err != nil {
 			return err
 		}
 	}
@@ -393,28 +941,102 @@ func (e *Encoder) asJSONLArray(w io.Writer, msgs ...wrp.Union) error {
 	return nil
 }
 
-func (e *Encoder) asJSONLSingle(pw *io.PipeWriter, msgs ...wrp.Union) {
-	go func() {
-		// Wrap the pipe writer with the compressor
-		cw, err := e.compressor(pw)
-		if err == nil {
-			err = e.asJSONLArray(cw, msgs...)
-			cw.Close()
-		}
+// encode validates msg and writes it to w in the format f.  Validation
+// failures are reported as ErrInvalidMessage so they can be told apart from
+// write failures.
+func (e *Encoder) encode(f wrp.Format, w io.Writer, msg wrp.Union) error {
+	m, err := e.toMessage(msg)
+	if err != nil {
+		return err
+	}
+
+	if !e.canonical {
+		return f.Encoder(w).Encode(m, wrp.NoStandardValidation())
+	}
+
+	canonicalize(m)
+	if f != wrp.Msgpack {
+		return f.Encoder(w).Encode(m, wrp.NoStandardValidation())
+	}
+
+	var b []byte
+	if err = f.EncoderBytes(&b).Encode(m, wrp.NoStandardValidation()); err != nil {
+		return err
+	}
+	if b, err = sortMsgpackMetadata(b); err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	return err
+}
+
+// toMessage converts msg into a wrp.Message, running the validators.
+func (e *Encoder) toMessage(msg wrp.Union) (*wrp.Message, error) {
+	if isNil(msg) {
+		return nil, fmt.Errorf("%w: message is nil", ErrInvalidMessage)
+	}
+
+	var m wrp.Message
+	if err := msg.To(&m, e.validator...); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
+	}
+
+	return &m, nil
+}
+
+// isNil reports whether msg is nil or a nil pointer.  A wrp.Union may also be
+// implemented on a value type, which can never be nil.
+func isNil(msg wrp.Union) bool {
+	if msg == nil {
+		return true
+	}
+	v := reflect.ValueOf(msg)
+	return v.Kind() == reflect.Pointer && v.IsNil()
+}
+
+// validateAll converts and validates every message, reporting the index of
+// the first one that fails.
+func (e *Encoder) validateAll(msgs []wrp.Union) ([]wrp.Union, error) {
+	rv := make([]wrp.Union, len(msgs))
+	for i, msg := range msgs {
+		m, err := e.toMessage(msg)
 		if err != nil {
-			pw.CloseWithError(err)
-			return
+			return nil, fmt.Errorf("message %d: %w", i, err)
+		}
+		rv[i] = m
+	}
+	return rv, nil
+}
+
+// finishHeaders sets the top level headers once the body layout is known.
+// A multipart body only carries the Content-Encoding when the whole envelope
+// is compressed, otherwise each part carries its own.
+func (e *Encoder) finishHeaders(headers http.Header, boundary string) {
+	if boundary != "" {
+		headers.Set("Content-Type", fmt.Sprintf("multipart/mixed; boundary=%s", boundary))
+		if !e.envelope {
+			headers.Del("Content-Encoding")
 		}
+	}
+	if len(e.vary) > 0 {
+		headers.Set("Vary", strings.Join(e.vary, ", "))
+	}
+}
 
-		pw.Close()
-	}()
+// partHeaders returns the headers for a multipart part.
+func (e *Encoder) partHeaders(h ...http.Header) http.Header {
+	headers := e.getHeaders(h...)
+	if e.envelope {
+		headers.Del("Content-Encoding")
+	}
+	return headers
 }
 
 func (e *Encoder) getHeaders(h ...http.Header) http.Header {
 	h = append(h, make(http.Header, 2))
 	h[0].Set("Content-Type", e.getContentType())
-	if e.encoding != "" && e.encoding != "identity" {
-		h[0].Set("Content-Encoding", e.encoding)
+	if name := e.coding.Name(); name != identityCoding {
+		h[0].Set("Content-Encoding", name)
 	}
 	return h[0]
 }
@@ -435,24 +1057,64 @@ func (e *Encoder) getContentType() string {
 	return e.mt.String()
 }
 
+// chunked splits an iterator into chunks of at most perChunk messages.  Each
+// chunk must be fully consumed or abandoned before the next is requested.
 type chunked struct {
-	list     []wrp.Union
+	next     func() (wrp.Union, bool)
+	stop     func()
 	perChunk int
-	current  int
+
+	// pending is the message read ahead to find out if there is another
+	// chunk.  It may be nil, so hasPending tells if there is one.
+	pending    wrp.Union
+	hasPending bool
+	done       bool
 }
 
-func (c *chunked) Next() []wrp.Union {
-	if c.current >= len(c.list) {
+func newChunked(msgs iter.Seq[wrp.Union], perChunk int) *chunked {
+	next, stop := iter.Pull(msgs)
+	return &chunked{
+		next:     next,
+		stop:     stop,
+		perChunk: perChunk,
+	}
+}
+
+// Next returns an iterator over the next chunk, or nil if there are no more
+// messages.
+func (c *chunked) Next() iter.Seq[wrp.Union] {
+	if c.done {
 		return nil
 	}
 
-	end := c.current + c.perChunk
-	if end > len(c.list) {
-		end = len(c.list)
+	if !c.hasPending {
+		msg, ok := c.next()
+		if !ok {
+			c.done = true
+			return nil
+		}
+		c.pending, c.hasPending = msg, true
 	}
 
-	chunk := c.list[c.current:end]
-	c.current = end
+	return func(yield func(wrp.Union) bool) {
+		for i := 0; i < c.perChunk; i++ {
+			msg, ok := c.pending, c.hasPending
+			c.pending, c.hasPending = nil, false
+			if !ok {
+				msg, ok = c.next()
+				if !ok {
+					c.done = true
+					return
+				}
+			}
+			if !yield(msg) {
+				return
+			}
+		}
+	}
+}
 
-	return chunk
+// Stop releases the underlying iterator.
+func (c *chunked) Stop() {
+	c.stop()
 }
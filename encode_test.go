@@ -4,11 +4,19 @@
 package wrphttp
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -65,6 +73,26 @@ func TestNewEncoder(t *testing.T) {
 			},
 			err: true,
 		},
+		{
+			name: "negotiate media type, preferred list",
+			opts: []Option{
+				AsNegotiated(&http.Request{
+					Header: http.Header{
+						"Accept": []string{
+							"*/*",
+						},
+					},
+				}, WithPreferredMediaTypes(MEDIA_TYPE_JSON)),
+			},
+			err: false,
+		},
+		{
+			name: "negotiate media type, plain GET",
+			opts: []Option{
+				AsNegotiated(httptest.NewRequest(http.MethodGet, "http://example.com", nil)),
+			},
+			err: false,
+		},
 		{
 			name: "invalid OctetStream",
 			opts: []Option{
@@ -137,6 +165,27 @@ func TestNewRequestWithContext(t *testing.T) {
 	}
 }
 
+func TestNewRequestInvalidURL(t *testing.T) {
+	encoder, err := NewEncoder(EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+	msgs := toUnion(testWRPMessages)
+
+	before := runtime.NumGoroutine()
+	for range 20 {
+		_, err = encoder.NewRequestWithContext(context.Background(), http.MethodPost, "://bad", msgs...)
+		require.Error(t, err)
+		_, err = encoder.NewRequestSeqWithContext(context.Background(), "bad method", "http://example.com", slices.Values(msgs))
+		require.Error(t, err)
+	}
+
+	// The encoding goroutines stop once their bodies are closed.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.LessOrEqual(t, runtime.NumGoroutine(), before)
+}
+
 func TestAsParts(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -243,7 +292,7 @@ func TestAsParts(t *testing.T) {
 			require.NotNil(t, encoder)
 			headers, body, err := encoder.ToParts(test.msgs...)
 			if test.err {
-				require.Error(t, err)
+				require.ErrorIs(t, err, ErrInvalidMessage)
 				assert.Nil(t, headers)
 				assert.Nil(t, body)
 				return
@@ -255,7 +304,7 @@ func TestAsParts(t *testing.T) {
 			// Read the body to ensure it is not empty
 			n, err := io.Copy(io.Discard, body)
 			if test.readErr {
-				require.Error(t, err)
+				require.ErrorIs(t, err, ErrInvalidMessage)
 				return
 			}
 
@@ -357,6 +406,73 @@ func TestEncodeRequests(t *testing.T) {
 				assert.Equal(t, 2, count)
 			},
 		},
+		{
+			name: "compress the envelope, multiple messages, gzip",
+			opts: []Option{
+				EncodeValidators(wrp.NoStandardValidation()),
+				AsMsgpack(),
+				EncodeGzip(),
+				CompressEnvelope(),
+			},
+			msgs: []wrp.Message{
+				testWRPMessages[0],
+				testWRPMessages[1],
+			},
+			check: func(t *testing.T, req *http.Request) {
+				assert.True(t, strings.HasPrefix(req.Header.Get("Content-Type"), "multipart/mixed;"))
+				assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+
+				gr, err := gzip.NewReader(req.Body)
+				require.NoError(t, err)
+				req.Body = io.NopCloser(gr)
+
+				mp, err := req.MultipartReader()
+				require.NoError(t, err)
+
+				var count int
+				for {
+					part, err := mp.NextPart()
+					if err == io.EOF {
+						break
+					}
+					require.NoError(t, err)
+					assert.Equal(t, MEDIA_TYPE_MSGPACK, part.Header.Get("Content-Type"))
+					assert.Empty(t, part.Header.Get("Content-Encoding"))
+					count++
+				}
+
+				assert.Equal(t, 2, count)
+			},
+		},
+		{
+			name: "compress the envelope, single message, gzip",
+			opts: []Option{
+				EncodeValidators(wrp.NoStandardValidation()),
+				EncodeGzip(),
+				CompressEnvelope(),
+			},
+			msgs: []wrp.Message{
+				testWRPMessages[0],
+			},
+			check: func(t *testing.T, req *http.Request) {
+				assert.Equal(t, MEDIA_TYPE_MSGPACK, req.Header.Get("Content-Type"))
+				assert.Equal(t, "gzip", req.Header.Get("Content-Encoding"))
+			},
+		},
+		{
+			name: "per part compression does not list a coding on the envelope",
+			opts: []Option{
+				EncodeValidators(wrp.NoStandardValidation()),
+				EncodeGzip(),
+			},
+			msgs: []wrp.Message{
+				testWRPMessages[0],
+				testWRPMessages[1],
+			},
+			check: func(t *testing.T, req *http.Request) {
+				assert.Empty(t, req.Header.Get("Content-Encoding"))
+			},
+		},
 		{
 			name: "don't encode the parameter in the content type in compatibility mode",
 			opts: []Option{
@@ -442,3 +558,459 @@ func TestEncodeRequests(t *testing.T) {
 		})
 	}
 }
+
+func TestToPartsSeq(t *testing.T) {
+	typs := []testOption{
+		{AsJSON(), "AsJSON"},
+		{AsJSONL(), "AsJSONL"},
+		{AsMsgpack(), "AsMsgpack"},
+		{AsMsgpackL(), "AsMsgpackL"},
+		{AsOctetStream(), "AsOctetStream"},
+	}
+
+	chunks := []testOption{
+		{WithMaxItemsPerChunk(0), "default chunk"},
+		{WithMaxItemsPerChunk(2), "2 per chunk"},
+		{WithMaxItemsPerChunk(-1), "no chunk"},
+	}
+
+	for _, typ := range typs {
+		for _, chunk := range chunks {
+			for count := 1; count <= len(testWRPMessages); count++ {
+				msgs := testWRPMessages[:count]
+				name := fmt.Sprintf("%s %s %d messages", typ.name, chunk.name, count)
+				t.Run(name, func(t *testing.T) {
+					encoder, err := NewEncoder(typ.opt, chunk.opt, EncodeGzip(),
+						EncodeValidators(wrp.NoStandardValidation()))
+					require.NoError(t, err)
+
+					headers, body, err := encoder.ToPartsSeq(slices.Values(toUnion(msgs)))
+					require.NoError(t, err)
+
+					got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+					require.NoError(t, err)
+					require.Len(t, got, len(msgs))
+					for i := range msgs {
+						assert.Equal(t, msgs[i], *got[i].(*wrp.Message))
+					}
+				})
+			}
+		}
+	}
+
+	t.Run("from a channel", func(t *testing.T) {
+		encoder, err := NewEncoder(AsMsgpackL(), EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		ch := make(chan wrp.Union)
+		go func() {
+			defer close(ch)
+			for _, msg := range toUnion(testWRPMessages) {
+				ch <- msg
+			}
+		}()
+
+		req, err := encoder.NewRequestSeq(http.MethodPost, "http://example.com", FromChan(ch))
+		require.NoError(t, err)
+
+		got, err := DecodeRequest(req, wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	})
+
+	t.Run("no messages", func(t *testing.T) {
+		encoder, err := NewEncoder()
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToPartsSeq(slices.Values([]wrp.Union{}))
+		require.Error(t, err)
+		assert.Nil(t, headers)
+		assert.Nil(t, body)
+
+		req, err := encoder.NewRequestSeq(http.MethodPost, "http://example.com", nil)
+		require.Error(t, err)
+		assert.Nil(t, req)
+	})
+
+	t.Run("nil message fails during read", func(t *testing.T) {
+		msgs := toUnion(testWRPMessages)
+		withNil := []wrp.Union{msgs[0], msgs[1], nil, msgs[2]}
+
+		for _, opts := range [][]Option{
+			{AsJSONL(), WithMaxItemsPerChunk(1)},
+			{AsJSONL(), WithMaxItemsPerChunk(2)},
+			{AsMsgpackL(), WithMaxItemsPerChunk(2)},
+			{AsJSON()},
+		} {
+			encoder, err := NewEncoder(append(opts, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			_, body, err := encoder.ToPartsSeq(slices.Values(withNil))
+			require.NoError(t, err)
+			_, err = io.Copy(io.Discard, body)
+			require.ErrorIs(t, err, ErrInvalidMessage)
+			assert.ErrorContains(t, err, "message is nil")
+
+			ch := make(chan wrp.Union, len(withNil))
+			for _, msg := range withNil {
+				ch <- msg
+			}
+			close(ch)
+			_, body, err = encoder.ToPartsSeq(FromChan(ch))
+			require.NoError(t, err)
+			_, err = io.Copy(io.Discard, body)
+			require.ErrorIs(t, err, ErrInvalidMessage)
+		}
+	})
+
+	t.Run("invalid message fails during read", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), WithMaxItemsPerChunk(1))
+		require.NoError(t, err)
+
+		msgs := []wrp.Union{
+			&wrp.Message{Source: "source"},
+			&wrp.Message{Source: "source"},
+		}
+		_, body, err := encoder.ToPartsSeq(slices.Values(msgs))
+		require.NoError(t, err)
+
+		_, err = io.Copy(io.Discard, body)
+		require.Error(t, err)
+	})
+}
+
+func TestEncodeNegotiated(t *testing.T) {
+	req := &http.Request{
+		Header: http.Header{
+			"Accept":          []string{"application/jsonl"},
+			"Accept-Encoding": []string{"gzip;q=0.5, deflate;q=0.2"},
+		},
+	}
+
+	encoder, err := NewEncoder(
+		AsNegotiated(req),
+		EncodeNegotiated(req),
+		EncodeValidators(wrp.NoStandardValidation()),
+	)
+	require.NoError(t, err)
+
+	headers, body, err := encoder.ToParts(toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+	assert.Equal(t, MEDIA_TYPE_JSONL, headers.Get("Content-Type"))
+	assert.Equal(t, "gzip", headers.Get("Content-Encoding"))
+	assert.Equal(t, "Accept, Accept-Encoding", headers.Get("Vary"))
+
+	got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+	require.NoError(t, err)
+	assert.Len(t, got, len(testWRPMessages))
+
+	_, err = NewEncoder(EncodeNegotiated(&http.Request{
+		Header: http.Header{
+			"Accept-Encoding": []string{"identity;q=0"},
+		},
+	}))
+	require.ErrorIs(t, err, ErrNotAcceptableEncoding)
+}
+
+func TestBuffered(t *testing.T) {
+	encoder, err := NewEncoder(AsJSON(), EncodeGzip(), Buffered(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	req, err := encoder.NewRequest(http.MethodPost, "http://example.com", toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+	assert.Greater(t, req.ContentLength, int64(0))
+	assert.Empty(t, req.Header.Get("Content-Length"))
+	require.NotNil(t, req.GetBody)
+
+	first, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Len(t, first, int(req.ContentLength))
+
+	body, err := req.GetBody()
+	require.NoError(t, err)
+	second, err := io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	req.Body, err = req.GetBody()
+	require.NoError(t, err)
+	got, err := DecodeRequest(req, wrp.NoStandardValidation())
+	require.NoError(t, err)
+	assert.Len(t, got, len(testWRPMessages))
+
+	t.Run("content length header", func(t *testing.T) {
+		headers, body, err := encoder.ToParts(toUnion(testWRPMessages[:1])...)
+		require.NoError(t, err)
+		b, err := io.ReadAll(body)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprint(len(b)), headers.Get("Content-Length"))
+	})
+
+	t.Run("encoding errors are returned directly", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), Buffered())
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(&wrp.Message{Source: "source"})
+		require.ErrorIs(t, err, ErrInvalidMessage)
+		assert.Nil(t, headers)
+		assert.Nil(t, body)
+	})
+}
+
+func TestToPartsWithContext(t *testing.T) {
+	encoder, err := NewEncoder(AsJSON(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	t.Run("cancel stops an unread body", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan struct{})
+		msgs := func(yield func(wrp.Union) bool) {
+			defer close(done)
+			for {
+				if !yield(&testWRPMessages[0]) {
+					return
+				}
+			}
+		}
+
+		_, body, err := encoder.ToPartsSeqWithContext(ctx, msgs)
+		require.NoError(t, err)
+
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "the encoding goroutine did not exit")
+		}
+
+		_, err = io.ReadAll(body)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("cancel is reported by reads", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		_, body, err := encoder.ToPartsWithContext(ctx, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+
+		cancel()
+		_, err = io.ReadAll(body)
+		require.ErrorIs(t, err, context.Canceled)
+	})
+
+	t.Run("nil context", func(t *testing.T) {
+		_, _, err := encoder.ToPartsWithContext(nil, toUnion(testWRPMessages)...) // nolint: staticcheck
+		require.Error(t, err)
+		_, _, err = encoder.ToPartsSeqWithContext(nil, slices.Values(toUnion(testWRPMessages))) // nolint: staticcheck
+		require.Error(t, err)
+	})
+}
+
+func TestEagerValidation(t *testing.T) {
+	invalid := &wrp.Message{
+		Source:      "source",
+		Destination: "destination",
+	}
+
+	typs := []testOption{
+		{AsJSON(), "AsJSON"},
+		{AsJSONL(), "AsJSONL"},
+		{AsMsgpack(), "AsMsgpack"},
+		{AsMsgpackL(), "AsMsgpackL"},
+		{AsOctetStream(), "AsOctetStream"},
+	}
+
+	for _, typ := range typs {
+		t.Run(typ.name, func(t *testing.T) {
+			encoder, err := NewEncoder(typ.opt, EagerValidation())
+			require.NoError(t, err)
+
+			valid := wrp.Message{
+				Type:            wrp.SimpleRequestResponseMessageType,
+				Source:          "dns:example.com",
+				Destination:     "mac:112233445566/service",
+				TransactionUUID: "uuid",
+			}
+			headers, body, err := encoder.ToParts(&valid, invalid)
+			require.ErrorIs(t, err, ErrInvalidMessage)
+			assert.Contains(t, err.Error(), "message 1")
+			assert.Nil(t, headers)
+			assert.Nil(t, body)
+
+			headers, body, err = encoder.ToPartsSeq(slices.Values([]wrp.Union{&valid, invalid}))
+			require.ErrorIs(t, err, ErrInvalidMessage)
+			assert.Nil(t, headers)
+			assert.Nil(t, body)
+
+			headers, body, err = encoder.ToParts(&valid, &valid)
+			require.NoError(t, err)
+			got, err := DecodeFromParts(headers, io.NopCloser(body))
+			require.NoError(t, err)
+			assert.Len(t, got, 2)
+		})
+	}
+}
+
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushes int
+}
+
+func (f *flushRecorder) Flush() {
+	f.flushes++
+	f.ResponseRecorder.Flush()
+}
+
+func TestWriteResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		flushes int
+	}{
+		{"jsonl lines", []Option{AsJSONL()}, 3},
+		{"jsonl lines, gzip", []Option{AsJSONL(), EncodeGzip()}, 3},
+		{"msgpackl items", []Option{AsMsgpackL()}, 3},
+		{"jsonl chunks", []Option{AsJSONL(), WithMaxItemsPerChunk(2)}, 5},
+		{"multipart parts", []Option{AsMsgpack()}, 3},
+		{"compressed envelope", []Option{AsJSON(), EncodeGzip(), CompressEnvelope()}, 3},
+		{"buffered", []Option{AsJSONL(), Buffered()}, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := NewEncoder(append(test.opts, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			rec := flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+			err = encoder.WriteResponse(&rec, http.StatusAccepted, toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+			assert.Equal(t, test.flushes, rec.flushes)
+
+			resp := rec.Result()
+			assert.Equal(t, http.StatusAccepted, resp.StatusCode)
+			got, err := DecodeResponse(resp, wrp.NoStandardValidation())
+			require.NoError(t, err)
+			assert.Len(t, got, len(testWRPMessages))
+		})
+	}
+
+	t.Run("seq", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		rec := flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+		err = encoder.WriteResponseSeq(&rec, http.StatusOK, slices.Values(toUnion(testWRPMessages)))
+		require.NoError(t, err)
+		assert.Equal(t, 4, rec.flushes)
+
+		got, err := DecodeResponse(rec.Result(), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		assert.Len(t, got, len(testWRPMessages))
+	})
+
+	t.Run("vary is merged", func(t *testing.T) {
+		encoder, err := NewEncoder(
+			AsNegotiated(&http.Request{Header: http.Header{"Accept": []string{"application/jsonl"}}}),
+			EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		rec.Header().Set("Vary", "Origin, accept")
+		err = encoder.WriteResponse(rec, http.StatusOK, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Origin, accept"}, rec.Header().Values("Vary"))
+
+		rec = httptest.NewRecorder()
+		rec.Header().Set("Vary", "Origin")
+		err = encoder.WriteResponse(rec, http.StatusOK, toUnion(testWRPMessages)...)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Origin", "Accept"}, rec.Header().Values("Vary"))
+	})
+
+	t.Run("errors before the status is written", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), EagerValidation())
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		err = encoder.WriteResponse(rec, http.StatusOK, &wrp.Message{Source: "source"})
+		require.ErrorIs(t, err, ErrInvalidMessage)
+		require.NotErrorIs(t, err, ErrPartialResponse)
+		assert.False(t, rec.Flushed)
+		assert.Empty(t, rec.Header())
+	})
+
+	t.Run("errors after the status is written", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL())
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		err = encoder.WriteResponse(rec, http.StatusOK, &wrp.Message{Source: "source"})
+		require.ErrorIs(t, err, ErrInvalidMessage)
+		require.ErrorIs(t, err, ErrPartialResponse)
+	})
+}
+
+func TestEncodeTo(t *testing.T) {
+	encoder, err := NewEncoder(AsMsgpack(), EncodeGzip(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	headers, err := encoder.EncodeTo(&buf, toUnion(testWRPMessages)...)
+	require.NoError(t, err)
+
+	got, err := DecodeFromParts(headers, io.NopCloser(&buf), wrp.NoStandardValidation())
+	require.NoError(t, err)
+	require.Len(t, got, len(testWRPMessages))
+	for i := range testWRPMessages {
+		assert.Equal(t, testWRPMessages[i], *got[i].(*wrp.Message))
+	}
+
+	_, err = encoder.EncodeTo(&buf)
+	require.ErrorIs(t, err, ErrNoMessages)
+}
+
+// valueUnion implements wrp.Union on a value type, so it can never be nil.
+type valueUnion struct {
+	msg wrp.Message
+}
+
+func (u valueUnion) MsgType() wrp.MessageType                    { return u.msg.Type }
+func (u valueUnion) From(*wrp.Message, ...wrp.Processor) error   { return errors.New("read only") }
+func (u valueUnion) To(m *wrp.Message, v ...wrp.Processor) error { return u.msg.To(m, v...) }
+func (u valueUnion) Validate(v ...wrp.Processor) error           { return u.msg.Validate(v...) }
+
+func TestValueUnion(t *testing.T) {
+	msg := testWRPMessages[0]
+	msg.SetStatus(http.StatusAccepted)
+	u := valueUnion{msg: msg}
+
+	for _, opts := range [][]Option{{AsJSON()}, {AsMsgpackL()}, {AsOctetStream()}} {
+		encoder, err := NewEncoder(append(opts, EncodeValidators(wrp.NoStandardValidation()))...)
+		require.NoError(t, err)
+
+		headers, body, err := encoder.ToParts(u, u)
+		require.NoError(t, err)
+		got, err := DecodeFromParts(headers, io.NopCloser(body), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		require.Len(t, got, 2)
+		assert.Equal(t, msg, *got[0].(*wrp.Message))
+	}
+
+	reply := NewErrorMessage(u, http.StatusBadRequest, errors.New("failed"))
+	assert.Equal(t, msg.TransactionUUID, reply.TransactionUUID)
+
+	status, ok := MessageStatus(u)
+	assert.True(t, ok)
+	assert.Equal(t, http.StatusAccepted, status)
+
+	assert.Equal(t, msg.TransactionUUID, NewBatchResult(u, http.StatusOK, nil).TransactionUUID)
+	results, err := BatchResults([]wrp.Union{u}, http.StatusOK)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, results[0].Status)
+
+	c, err := NewConverter(ConvertValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+	rec := httptest.NewRecorder()
+	require.NoError(t, c.WriteResponse(rec, u))
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+}
@@ -0,0 +1,284 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	// ErrNotAcceptable is returned when none of the media types the client
+	// accepts can be produced.  Servers can use it to respond with
+	// http.StatusNotAcceptable.
+	ErrNotAcceptable = errors.New("no acceptable content type found")
+
+	// ErrNotAcceptableEncoding is returned when none of the content codings
+	// the client accepts can be produced.  Servers can use it to respond with
+	// http.StatusNotAcceptable.
+	ErrNotAcceptableEncoding = errors.New("no acceptable content coding found")
+
+	// ErrUnsupportedMediaType is returned when a Content-Type or media type is
+	// not one this package can encode or decode.  Servers can use it to respond
+	// with http.StatusUnsupportedMediaType.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+
+	// ErrUnsupportedEncoding is returned when a Content-Encoding is not
+	// supported.  Servers can use it to respond with
+	// http.StatusUnsupportedMediaType.
+	ErrUnsupportedEncoding = errors.New("unsupported content encoding")
+
+	// ErrInvalidContentType is returned when the Content-Type header is missing
+	// or malformed.  Servers can use it to respond with http.StatusBadRequest.
+	ErrInvalidContentType = errors.New("invalid Content-Type")
+
+	// ErrInvalidMessage is returned when a message cannot be encoded or decoded,
+	// including when it fails validation.  Servers can use it to respond with
+	// http.StatusBadRequest.
+	ErrInvalidMessage = errors.New("invalid message")
+
+	// ErrNoMessages is returned when there are no messages to encode.
+	ErrNoMessages = errors.New("no messages provided")
+
+	// ErrNilRequest is returned when a nil *http.Request is provided.
+	ErrNilRequest = errors.New("request is nil")
+
+	// ErrNilResponse is returned when a nil *http.Response is provided.
+	ErrNilResponse = errors.New("response is nil")
+
+	// ErrNoReply is reported by the Client for a message that did not receive
+	// a reply.
+	ErrNoReply = errors.New("no reply received")
+
+	// ErrPartialResponse is returned when writing a response fails after the
+	// status code has been written, so no other response can be sent.
+	ErrPartialResponse = errors.New("response partially written")
+
+	// ErrInvalidSignature is returned when the X-Webpa-Signature of a body is
+	// missing or does not match any of the secrets.  Servers can use it to
+	// respond with http.StatusForbidden.
+	ErrInvalidSignature = errors.New("invalid signature")
+
+	// ErrDigestMismatch is matched by every DigestError.  Servers can use it
+	// to respond with http.StatusBadRequest.
+	ErrDigestMismatch = errors.New("content digest mismatch")
+
+	// ErrLimitExceeded is matched by every LimitError.  Servers can use it to
+	// respond with http.StatusRequestEntityTooLarge.
+	ErrLimitExceeded = errors.New("limit exceeded")
+)
+
+// The limits that can be reported by a LimitError.
+const (
+	LimitCompressedBytes   = "compressed bytes"
+	LimitDecompressedBytes = "decompressed bytes"
+	LimitMessages          = "messages"
+	LimitParts             = "parts"
+	LimitLineLength        = "line length"
+	LimitPayloadSize       = "payload size"
+)
+
+// LimitError is returned when one of the limits configured on a Decoder is
+// exceeded.
+type LimitError struct {
+	// Limit is the name of the limit that was exceeded.
+	Limit string
+
+	// Max is the configured value of the limit.
+	Max int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// Is allows errors.Is(err, ErrLimitExceeded) to match any LimitError.
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded // nolint: errorlint
+}
+
+// errNotUTF8 is the cause of a FieldError for a value that is not valid UTF-8.
+var errNotUTF8 = errors.New("not valid UTF-8")
+
+// FieldError is returned when a field of a message cannot be written to, or
+// read from, the HTTP headers of the octet-stream media type.  It matches
+// ErrInvalidMessage.
+type FieldError struct {
+	// Field is the name of the wrp.Message field, for example "Source".
+	Field string
+
+	// Err describes what is wrong with the value.
+	Err error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s field: %v", e.Field, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Is allows errors.Is(err, ErrInvalidMessage) to match any FieldError.
+func (e *FieldError) Is(target error) bool {
+	return target == ErrInvalidMessage // nolint: errorlint
+}
+
+// DigestError is returned when a Content-Digest does not match the body, or
+// the multipart part, it describes.  It is reported once the messages it
+// covers have been decoded.
+type DigestError struct {
+	// Algorithm is the digest algorithm that did not match.
+	Algorithm string
+
+	// Part is the zero based index of the multipart part that did not match,
+	// or -1 for the whole body.
+	Part int
+}
+
+func (e *DigestError) Error() string {
+	if e.Part >= 0 {
+		return fmt.Sprintf("%s %s mismatch (part %d)", ContentDigestHeader, e.Algorithm, e.Part)
+	}
+	return fmt.Sprintf("%s %s mismatch", ContentDigestHeader, e.Algorithm)
+}
+
+// Is allows errors.Is(err, ErrDigestMismatch) to match any DigestError.
+func (e *DigestError) Is(target error) bool {
+	return target == ErrDigestMismatch // nolint: errorlint
+}
+
+// DecodeError describes where in a body decoding failed.  The underlying error
+// is available via errors.Is and errors.As, and matches one of the exported
+// sentinel errors or is a *LimitError.
+type DecodeError struct {
+	// Part is the zero based index of the multipart part that failed, or -1 if
+	// the body is not multipart.
+	Part int
+
+	// Item is the one based JSONL line or MsgpackL item number that failed, or
+	// 0 if the media type holds a single message.
+	Item int
+
+	// MediaType is the media type of the failing part, if it is known.
+	MediaType string
+
+	// Err is the underlying error.
+	Err error
+
+	// skipped is set when lenient decoding carried on past the failure.
+	skipped bool
+}
+
+func (e *DecodeError) Error() string {
+	var where []string
+	if e.Part >= 0 {
+		where = append(where, fmt.Sprintf("part %d", e.Part))
+	}
+	if e.Item > 0 {
+		where = append(where, fmt.Sprintf("item %d", e.Item))
+	}
+	if e.MediaType != "" {
+		where = append(where, e.MediaType)
+	}
+	if len(where) == 0 {
+		return fmt.Sprintf("decode failed: %v", e.Err)
+	}
+	return fmt.Sprintf("decode failed (%s): %v", strings.Join(where, ", "), e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// BatchError is returned along with the messages that were decoded when
+// LenientDecoding skipped some of the messages in a body.  Each failure
+// describes the part and item that was skipped and why.
+type BatchError struct {
+	// Failures are the skipped messages, in the order they were found.
+	Failures []*DecodeError
+}
+
+func (e *BatchError) Error() string {
+	if len(e.Failures) == 1 {
+		return e.Failures[0].Error()
+	}
+	return fmt.Sprintf("%d messages failed to decode, first: %v", len(e.Failures), e.Failures[0])
+}
+
+// Unwrap returns the failures, so errors.Is and errors.As look at each of
+// them.
+func (e *BatchError) Unwrap() []error {
+	rv := make([]error, 0, len(e.Failures))
+	for _, f := range e.Failures {
+		rv = append(rv, f)
+	}
+	return rv
+}
+
+// ResponseError is returned by the Client when the server replies with a
+// status code outside of the 2xx range and the reply holds no WRP messages.
+type ResponseError struct {
+	// StatusCode is the HTTP status code of the reply.
+	StatusCode int
+
+	// Err is the error encountered decoding the reply, if any.
+	Err error
+}
+
+func (e *ResponseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("unexpected response status %d: %v", e.StatusCode, e.Err)
+	}
+	return fmt.Sprintf("unexpected response status %d", e.StatusCode)
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// StatusCoder is implemented by errors that know the HTTP status code they
+// should be reported with.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// StatusCode returns the HTTP status code that best describes err.  Errors
+// implementing StatusCoder report their own status code, the errors from this
+// package map to the status codes described with them and anything else is
+// reported as http.StatusInternalServerError.  A nil error maps to
+// http.StatusOK.
+func StatusCode(err error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+
+	var sc StatusCoder
+	if errors.As(err, &sc) {
+		return sc.StatusCode()
+	}
+
+	switch {
+	case errors.Is(err, ErrLimitExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrNotAcceptable),
+		errors.Is(err, ErrNotAcceptableEncoding):
+		return http.StatusNotAcceptable
+	case errors.Is(err, ErrInvalidSignature):
+		return http.StatusForbidden
+	case errors.Is(err, ErrUnsupportedMediaType),
+		errors.Is(err, ErrUnsupportedEncoding):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, ErrInvalidContentType),
+		errors.Is(err, ErrInvalidMessage),
+		errors.Is(err, ErrDigestMismatch),
+		errors.Is(err, ErrNoMessages),
+		errors.Is(err, ErrNilRequest):
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,101 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      DecodeError
+		expected string
+	}{
+		{
+			name:     "no location",
+			err:      DecodeError{Part: -1, Err: ErrInvalidMessage},
+			expected: "decode failed: invalid message",
+		}, {
+			name:     "part and media type",
+			err:      DecodeError{Part: 2, MediaType: MEDIA_TYPE_JSON, Err: ErrInvalidMessage},
+			expected: "decode failed (part 2, application/json): invalid message",
+		}, {
+			name:     "item",
+			err:      DecodeError{Part: -1, Item: 7, MediaType: MEDIA_TYPE_JSONL, Err: ErrInvalidMessage},
+			expected: "decode failed (item 7, application/jsonl): invalid message",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, test.err.Error())
+			assert.ErrorIs(t, &test.err, ErrInvalidMessage)
+		})
+	}
+}
+
+func TestBatchError(t *testing.T) {
+	one := &BatchError{Failures: []*DecodeError{
+		{Part: -1, Item: 2, Err: ErrInvalidMessage},
+	}}
+	assert.Equal(t, "decode failed (item 2): invalid message", one.Error())
+
+	two := &BatchError{Failures: []*DecodeError{
+		{Part: -1, Item: 2, Err: ErrInvalidMessage},
+		{Part: -1, Item: 5, Err: &LimitError{Limit: LimitPayloadSize, Max: 4}},
+	}}
+	assert.Equal(t, "2 messages failed to decode, first: decode failed (item 2): invalid message", two.Error())
+	assert.ErrorIs(t, two, ErrInvalidMessage)
+	assert.ErrorIs(t, two, ErrLimitExceeded)
+
+	var le *LimitError
+	require.ErrorAs(t, two, &le)
+	assert.Equal(t, LimitPayloadSize, le.Limit)
+}
+
+func TestLimitError(t *testing.T) {
+	var err error = &LimitError{Limit: LimitParts, Max: 3}
+
+	assert.Equal(t, "parts limit of 3 exceeded", err.Error())
+	assert.ErrorIs(t, err, ErrLimitExceeded)
+	assert.False(t, errors.Is(err, ErrInvalidMessage))
+}
+
+type statusErr int
+
+func (e statusErr) Error() string   { return "status error" }
+func (e statusErr) StatusCode() int { return int(e) }
+
+func TestStatusCode(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected int
+	}{
+		{"nil", nil, http.StatusOK},
+		{"limit", &LimitError{Limit: LimitParts, Max: 1}, http.StatusRequestEntityTooLarge},
+		{"not acceptable", ErrNotAcceptable, http.StatusNotAcceptable},
+		{"not acceptable encoding", ErrNotAcceptableEncoding, http.StatusNotAcceptable},
+		{"unsupported media type", ErrUnsupportedMediaType, http.StatusUnsupportedMediaType},
+		{"unsupported encoding", ErrUnsupportedEncoding, http.StatusUnsupportedMediaType},
+		{"invalid content type", ErrInvalidContentType, http.StatusBadRequest},
+		{"decode error", &DecodeError{Part: -1, Err: ErrInvalidMessage}, http.StatusBadRequest},
+		{"field error", &FieldError{Field: "Source", Err: errNotUTF8}, http.StatusBadRequest},
+		{"status coder", fmt.Errorf("wrapped: %w", statusErr(http.StatusTeapot)), http.StatusTeapot},
+		{"unknown", errors.New("unknown"), http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, StatusCode(test.err))
+		})
+	}
+}
@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// Handler responds to the WRP messages decoded from an HTTP request.  The
+// returned messages are encoded in the media type negotiated with the client.
+// Returning no messages and no error results in a 204 No Content response.
+type Handler interface {
+	ServeWRP(ctx context.Context, msgs []wrp.Union) ([]wrp.Union, error)
+}
+
+// HandlerFunc is an adapter to allow the use of ordinary functions as a
+// Handler.
+type HandlerFunc func(ctx context.Context, msgs []wrp.Union) ([]wrp.Union, error)
+
+// ServeWRP calls f(ctx, msgs).
+func (f HandlerFunc) ServeWRP(ctx context.Context, msgs []wrp.Union) ([]wrp.Union, error) {
+	return f(ctx, msgs)
+}
+
+// HandlerOption is a functional option for configuring the HTTPHandler.  The
+// options are applied in the order they are provided.
+type HandlerOption interface {
+	apply(*HTTPHandler) error
+}
+
+// ErrorHandlerFunc writes the response for an error that occurred while
+// serving a request.
+type ErrorHandlerFunc func(w http.ResponseWriter, r *http.Request, err error)
+
+// HTTPHandler adapts a Handler into an http.Handler.  It decodes the request,
+// negotiates the response format, calls the Handler and writes the encoded
+// response.  The HTTPHandler is safe for concurrent use once created.
+type HTTPHandler struct {
+	handler          Handler
+	decoder          *Decoder
+	decoderOpts      []DecoderOption
+	encoderOpts      []Option
+	negotiateOpts    []NegotiateOption
+	negotiateCodings bool
+	offeredCodings   []string
+	statusFuncs      []StatusFunc
+	statusPolicy     StatusPolicy
+	onError          ErrorHandlerFunc
+}
+
+// NewHTTPHandler creates a new HTTPHandler that calls h with the messages of
+// each request.  The options are applied in the order they are provided.
+//
+// By default the response media type is negotiated from the Accept header,
+// the response is sent with http.StatusOK, the response is not compressed and
+// the response messages are validated with EagerValidation before anything is
+// written.  Errors are written with WriteError, as WRP messages in the
+// negotiated media type that echo the TransactionUUID of each request message.
+func NewHTTPHandler(h Handler, opts ...HandlerOption) (*HTTPHandler, error) {
+	if h == nil {
+		return nil, errors.New("handler is nil")
+	}
+
+	handler := HTTPHandler{
+		handler: h,
+	}
+
+	for _, opt := range opts {
+		if opt != nil {
+			if err := opt.apply(&handler); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	decoder, err := NewDecoder(handler.decoderOpts...)
+	if err != nil {
+		return nil, err
+	}
+	handler.decoder = decoder
+
+	// Make sure the encoder options are valid before any request arrives.
+	if _, err := NewEncoder(handler.encoderOpts...); err != nil {
+		return nil, err
+	}
+
+	return &handler, nil
+}
+
+// ServeHTTP implements http.Handler.
+func (h *HTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	msgs, err := h.decoder.DecodeRequest(r)
+	if be := (*BatchError)(nil); errors.As(err, &be) && len(msgs) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), decodeFailuresKey{}, be.Failures))
+		err = nil
+	}
+	if err != nil {
+		h.fail(w, r, nil, err)
+		return
+	}
+
+	// Negotiate before calling the handler so a request that cannot be
+	// answered has no side effects.
+	encoder, err := h.newEncoder(r)
+	if err != nil {
+		h.fail(w, r, msgs, err)
+		return
+	}
+
+	resp, err := h.handler.ServeWRP(r.Context(), msgs)
+	if err != nil {
+		h.fail(w, r, msgs, err)
+		return
+	}
+
+	if len(resp) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	status := http.StatusOK
+	if h.statusPolicy != nil {
+		status = h.statusPolicy(resp)
+	}
+
+	err = encoder.WriteResponse(w, status, resp...)
+	if err != nil && !errors.Is(err, ErrPartialResponse) {
+		h.fail(w, r, msgs, responseError{err})
+	}
+}
+
+// fail writes the response for err, in reply to msgs if they are known.
+func (h *HTTPHandler) fail(w http.ResponseWriter, r *http.Request, msgs []wrp.Union, err error) {
+	if h.onError != nil {
+		h.onError(w, r, err)
+		return
+	}
+
+	writeError(w, r, err, msgs, h.negotiateOpts, h.statusFuncs)
+}
+
+func (h *HTTPHandler) newEncoder(r *http.Request) (*Encoder, error) {
+	opts := append([]Option{EagerValidation()}, h.encoderOpts...)
+	opts = append(opts, AsNegotiated(r, h.negotiateOpts...))
+	if h.negotiateCodings {
+		opts = append(opts, EncodeNegotiated(r, h.offeredCodings...))
+	}
+
+	return NewEncoder(opts...)
+}
+
+type decodeFailuresKey struct{}
+
+// DecodeFailures returns the messages of the request that were skipped by
+// LenientDecoding.  The HTTPHandler calls the Handler with the messages that
+// were decoded, and the Handler can use DecodeFailures to report the others.
+// A request where every message failed is rejected before the Handler is
+// called.
+func DecodeFailures(ctx context.Context) []*DecodeError {
+	failures, _ := ctx.Value(decodeFailuresKey{}).([]*DecodeError)
+	return failures
+}
+
+// TextErrorHandler writes err as plain text with the status code returned by
+// StatusCode.
+func TextErrorHandler(w http.ResponseWriter, _ *http.Request, err error) {
+	http.Error(w, err.Error(), StatusCode(err))
+}
+
+// responseError marks a failure to encode the response, which is the fault
+// of the server rather than the client.
+type responseError struct {
+	error
+}
+
+func (e responseError) Unwrap() error {
+	return e.error
+}
+
+func (responseError) StatusCode() int {
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func echo(_ context.Context, msgs []wrp.Union) ([]wrp.Union, error) {
+	return msgs, nil
+}
+
+func TestHTTPHandler(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  HandlerFunc
+		opts     []HandlerOption
+		encode   []Option
+		header   http.Header
+		body     string
+		status   int
+		mt       string
+		encoding string
+	}{
+		{
+			name:    "echo",
+			handler: echo,
+			encode:  []Option{AsMsgpack()},
+			status:  http.StatusOK,
+			mt:      MEDIA_TYPE_MSGPACKL,
+		}, {
+			name:    "echo in the accepted media type",
+			handler: echo,
+			encode:  []Option{AsMsgpack()},
+			header:  http.Header{"Accept": []string{MEDIA_TYPE_JSONL}},
+			status:  http.StatusOK,
+			mt:      MEDIA_TYPE_JSONL,
+		}, {
+			name:     "echo with a negotiated encoding",
+			handler:  echo,
+			opts:     []HandlerOption{WithNegotiatedEncoding()},
+			encode:   []Option{AsJSON()},
+			header:   http.Header{"Accept-Encoding": []string{"gzip"}},
+			status:   http.StatusOK,
+			mt:       MEDIA_TYPE_MSGPACKL,
+			encoding: "gzip",
+		}, {
+			name: "no response",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				return nil, nil
+			},
+			status: http.StatusNoContent,
+		}, {
+			name:    "not acceptable",
+			handler: echo,
+			header:  http.Header{"Accept": []string{"text/html"}},
+			status:  http.StatusNotAcceptable,
+		}, {
+			name:    "unsupported media type",
+			handler: echo,
+			header:  http.Header{"Content-Type": []string{"text/plain"}},
+			body:    "hello",
+			status:  http.StatusUnsupportedMediaType,
+		}, {
+			name:    "invalid message",
+			handler: echo,
+			header:  http.Header{"Content-Type": []string{MEDIA_TYPE_JSON}},
+			body:    "{",
+			status:  http.StatusBadRequest,
+		}, {
+			name:    "too many messages",
+			handler: echo,
+			opts: []HandlerOption{
+				WithDecoderOptions(WithMaxMessages(1)),
+			},
+			status: http.StatusRequestEntityTooLarge,
+		}, {
+			name: "invalid response",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				return []wrp.Union{&wrp.Message{Source: "source"}}, nil
+			},
+			opts: []HandlerOption{
+				WithEncoderOptions(EncodeValidators(wrp.StandardValidator())),
+			},
+			header: http.Header{"Accept": []string{MEDIA_TYPE_JSONL}},
+			status: http.StatusInternalServerError,
+		}, {
+			name: "handler error",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				return nil, errors.New("failed")
+			},
+			status: http.StatusInternalServerError,
+		}, {
+			name: "mapped error status",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				return nil, fmt.Errorf("wrapped: %w", context.DeadlineExceeded)
+			},
+			opts: []HandlerOption{
+				WithErrorStatus(context.DeadlineExceeded, http.StatusGatewayTimeout),
+			},
+			status: http.StatusGatewayTimeout,
+		}, {
+			name: "status policy",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				var msg wrp.Message
+				msg.SetStatus(http.StatusNotFound)
+				return []wrp.Union{&msg}, nil
+			},
+			opts:   []HandlerOption{WithStatusPolicy(HighestStatus)},
+			status: http.StatusNotFound,
+		}, {
+			name: "custom error handler",
+			handler: func(context.Context, []wrp.Union) ([]wrp.Union, error) {
+				return nil, errors.New("failed")
+			},
+			opts: []HandlerOption{
+				WithErrorHandler(func(w http.ResponseWriter, _ *http.Request, _ error) {
+					w.WriteHeader(http.StatusBadGateway)
+				}),
+			},
+			status: http.StatusBadGateway,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := append([]HandlerOption{
+				WithEncoderOptions(EncodeValidators(wrp.NoStandardValidation())),
+				WithDecoderOptions(DecodeValidators(wrp.NoStandardValidation())),
+			}, test.opts...)
+			h, err := NewHTTPHandler(test.handler, opts...)
+			require.NoError(t, err)
+
+			encoder, err := NewEncoder(append(test.encode, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			req, err := encoder.NewRequest(http.MethodPost, "http://example.com", toUnion(testWRPMessages[:2])...)
+			require.NoError(t, err)
+			if test.body != "" {
+				req = httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(test.body))
+			}
+			for k, v := range test.header {
+				req.Header[k] = v
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			resp := rec.Result()
+			defer resp.Body.Close()
+			require.Equal(t, test.status, resp.StatusCode)
+			if test.status != http.StatusOK {
+				return
+			}
+
+			if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "multipart/") {
+				assert.Equal(t, test.mt, ct)
+				assert.Equal(t, test.encoding, resp.Header.Get("Content-Encoding"))
+			}
+			assert.Contains(t, resp.Header.Get("Vary"), "Accept")
+
+			got, err := DecodeResponse(resp, wrp.NoStandardValidation())
+			require.NoError(t, err)
+			require.Len(t, got, 2)
+			for i := range got {
+				assert.Equal(t, testWRPMessages[i], *got[i].(*wrp.Message))
+			}
+		})
+	}
+
+	t.Run("lenient decoding", func(t *testing.T) {
+		var failures []*DecodeError
+		h, err := NewHTTPHandler(
+			HandlerFunc(func(ctx context.Context, msgs []wrp.Union) ([]wrp.Union, error) {
+				failures = DecodeFailures(ctx)
+				return msgs, nil
+			}),
+			WithDecoderOptions(LenientDecoding(), DecodeValidators(wrp.NoStandardValidation())),
+			WithEncoderOptions(EncodeValidators(wrp.NoStandardValidation())),
+		)
+		require.NoError(t, err)
+
+		body := `{"msg_type":4,"source":"source"}` + "\ninvalid\n"
+		for _, test := range []struct {
+			body   string
+			status int
+			msgs   int
+		}{
+			{body, http.StatusOK, 1},
+			{"invalid\n", http.StatusBadRequest, 0},
+		} {
+			failures = nil
+			req := httptest.NewRequest(http.MethodPost, "http://example.com", strings.NewReader(test.body))
+			req.Header.Set("Content-Type", MEDIA_TYPE_JSONL)
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			resp := rec.Result()
+			require.Equal(t, test.status, resp.StatusCode)
+			if test.msgs == 0 {
+				assert.Nil(t, failures)
+				continue
+			}
+
+			got, err := DecodeResponse(resp, wrp.NoStandardValidation())
+			require.NoError(t, err)
+			assert.Len(t, got, test.msgs)
+			require.Len(t, failures, 1)
+			assert.Equal(t, 2, failures[0].Item)
+		}
+
+		assert.Nil(t, DecodeFailures(context.Background()))
+	})
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewHTTPHandler(nil)
+		require.Error(t, err)
+
+		_, err = NewHTTPHandler(HandlerFunc(echo), WithEncoderOptions(AsMediaType("invalid")))
+		require.Error(t, err)
+
+		_, err = NewHTTPHandler(HandlerFunc(echo), WithErrorHandler(nil))
+		require.Error(t, err)
+
+		_, err = NewHTTPHandler(HandlerFunc(echo), WithErrorStatusFunc(nil))
+		require.Error(t, err)
+
+		_, err = NewHTTPHandler(HandlerFunc(echo), WithStatusPolicy(nil))
+		require.Error(t, err)
+	})
+}
@@ -6,9 +6,12 @@ package wrphttp
 import (
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/xmidt-org/wrp-go/v5"
 )
@@ -87,14 +90,60 @@ var (
 	serviceNameHeader     = hdr{"X-Xmidt-Service-Name" /*        */, "X-Midt-Service-Name" /*        */, "Xmidt-Service-Name" /*        */}
 	urlHeader             = hdr{"X-Xmidt-Url" /*                 */, "X-Midt-Url" /*                 */, "Xmidt-Url" /*                 */}
 	contentTypeHeader     = hdr{"X-Xmidt-Content-Type" /*        */, "X-Midt-Content-Type" /*        */, "Xmidt-Content-Type" /*        */}
+	qosHeader             = hdr{"X-Xmidt-Qos" /*                 */, "X-Midt-Qos" /*                 */, "Xmidt-Qos" /*                 */}
 )
 
-func toHeadersForm(msg wrp.Union, typ string, validators ...wrp.Processor) (http.Header, []byte, error) {
+// The characters that may not appear raw in the items of the list valued
+// headers.  Commas separate the items, and proxies may combine repeated
+// headers into one comma separated line, so they must never appear raw.
+// Metadata keys additionally reserve the colon that ends the key.
+const (
+	reservedItem = ","
+	reservedKey  = ",:"
+)
+
+// escapeItem returns s in a form that is safe to use as an item of a list
+// valued header.  Items with reserved characters, or that encodeValue would
+// encode, are written as an ext-value.  Other items are written as is, and
+// only ext-values are decoded again, so items written before escaping was
+// introduced keep their meaning, percent signs included.
+func escapeItem(s, reserved string) string {
+	if isPlain(s) && !strings.ContainsAny(s, reserved) {
+		return s
+	}
+	return extValue(s)
+}
+
+// splitItems splits the comma separated header values into their items.  The
+// items are still escaped.  For values made of "name:value" pairs, a piece
+// without a colon that is not an ext-value is the rest of the previous pair,
+// written before commas were escaped.
+func splitItems(values []string, pairs bool) []string {
+	var rv []string
+	for _, value := range values {
+		started := false
+		for _, item := range strings.Split(value, ",") {
+			trimmed := strings.TrimSpace(item)
+			if pairs && started && !strings.Contains(trimmed, ":") && !hasExtPrefix(trimmed) {
+				rv[len(rv)-1] += "," + strings.TrimRight(item, " \t")
+				continue
+			}
+			if trimmed == "" {
+				continue
+			}
+			rv = append(rv, trimmed)
+			started = true
+		}
+	}
+	return rv
+}
+
+func toHeadersForm(msg wrp.Union, typ string, canonical bool, validators ...wrp.Processor) (http.Header, []byte, error) {
 	headers := make(http.Header)
 
 	var out wrp.Message
 	if err := msg.To(&out, validators...); err != nil {
-		return nil, nil, err
+		return nil, nil, fmt.Errorf("%w: %w", ErrInvalidMessage, err)
 	}
 
 	h := wrpHeader{headers: headers, typ: typ}
@@ -103,32 +152,37 @@ func toHeadersForm(msg wrp.Union, typ string, validators ...wrp.Processor) (http
 
 	h.toIntPtrHeader(statusHeader, out.Status, headers)
 	h.toIntPtrHeader(rdrHeader, out.RequestDeliveryResponse, headers)
-	h.toStringHeader(transactionUuidHeader, out.TransactionUUID, headers)
-	h.toStringHeader(pathHeader, out.Path, headers)
-	h.toStringHeader(sourceHeader, out.Source, headers)
-	h.toStringHeader(destinationHeader, out.Destination, headers)
-	h.toStringHeader(acceptHeader, out.Accept, headers)
-	h.toStringHeader(sessionIdHeader, out.SessionID, headers)
-	h.toStringHeader(serviceNameHeader, out.ServiceName, headers)
-	h.toStringHeader(urlHeader, out.URL, headers)
-	h.toStringHeader(contentTypeHeader, out.ContentType, headers)
-	if out.Metadata != nil {
-		for k, v := range out.Metadata {
-			if v != "" {
-				headers.Add(metadataHeader.As(typ), fmt.Sprintf("%s:%s", k, v))
-			}
-		}
+	h.toStringHeader(transactionUuidHeader, "TransactionUUID", out.TransactionUUID, headers)
+	h.toStringHeader(pathHeader, "Path", out.Path, headers)
+	h.toStringHeader(sourceHeader, "Source", out.Source, headers)
+	h.toStringHeader(destinationHeader, "Destination", out.Destination, headers)
+	h.toStringHeader(acceptHeader, "Accept", out.Accept, headers)
+	h.toStringHeader(sessionIdHeader, "SessionID", out.SessionID, headers)
+	h.toStringHeader(serviceNameHeader, "ServiceName", out.ServiceName, headers)
+	h.toStringHeader(urlHeader, "URL", out.URL, headers)
+	h.toStringHeader(contentTypeHeader, "ContentType", out.ContentType, headers)
+	if out.QualityOfService != 0 {
+		headers.Set(qosHeader.As(typ), strconv.Itoa(int(out.QualityOfService)))
 	}
-	partners := strings.Join(out.PartnerIDs, ",")
-	if partners != "" {
-		headers.Set(partnerIdHeader.As(typ), partners)
+	for _, k := range slices.Sorted(maps.Keys(out.Metadata)) {
+		headers.Add(metadataHeader.As(typ), h.item("Metadata", k, reservedKey)+":"+h.item("Metadata", out.Metadata[k], reservedItem))
 	}
-	if out.Headers != nil {
-		for _, v := range out.Headers {
-			if v != "" {
-				headers.Add(headersHeader.As(typ), v)
-			}
-		}
+	if canonical {
+		canonicalize(&out)
+	}
+	partners := make([]string, 0, len(out.PartnerIDs))
+	for _, p := range out.PartnerIDs {
+		partners = append(partners, h.listItem("PartnerIDs", p, false))
+	}
+	if len(partners) > 0 {
+		headers.Set(partnerIdHeader.As(typ), strings.Join(partners, ","))
+	}
+	for _, v := range out.Headers {
+		headers.Add(headersHeader.As(typ), h.listItem("Headers", v, true))
+	}
+
+	if h.err != nil {
+		return nil, nil, h.err
 	}
 
 	return headers, out.Payload, nil
@@ -143,20 +197,28 @@ func fromHeaders(headers http.Header, body io.ReadCloser, validators ...wrp.Proc
 
 	h := wrpHeader{headers: headers}
 
-	h.readString(transactionUuidHeader, &msg.TransactionUUID)
+	h.readString(transactionUuidHeader, "TransactionUUID", &msg.TransactionUUID)
 	h.readInt(statusHeader, &msg.Status)
 	h.readInt(rdrHeader, &msg.RequestDeliveryResponse)
-	h.readString(pathHeader, &msg.Path)
-	h.readString(sourceHeader, &msg.Source)
-	h.readString(destinationHeader, &msg.Destination)
-	h.readString(acceptHeader, &msg.Accept)
-	h.readString(sessionIdHeader, &msg.SessionID)
-	h.readString(serviceNameHeader, &msg.ServiceName)
-	h.readString(urlHeader, &msg.URL)
-	h.readStrings(partnerIdHeader, &msg.PartnerIDs)
-	h.readHashmap(metadataHeader, &msg.Metadata)
-	h.readHeaders(headersHeader, &msg.Headers)
-	h.readString(contentTypeHeader, &msg.ContentType)
+	h.readString(pathHeader, "Path", &msg.Path)
+	h.readString(sourceHeader, "Source", &msg.Source)
+	h.readString(destinationHeader, "Destination", &msg.Destination)
+	h.readString(acceptHeader, "Accept", &msg.Accept)
+	h.readString(sessionIdHeader, "SessionID", &msg.SessionID)
+	h.readString(serviceNameHeader, "ServiceName", &msg.ServiceName)
+	h.readString(urlHeader, "URL", &msg.URL)
+	h.readStrings(partnerIdHeader, "PartnerIDs", &msg.PartnerIDs)
+	h.readHashmap(metadataHeader, "Metadata", &msg.Metadata)
+	h.readHeaders(headersHeader, "Headers", &msg.Headers)
+	h.readString(contentTypeHeader, "ContentType", &msg.ContentType)
+	h.readQOS(qosHeader, &msg.QualityOfService)
+
+	if h.err != nil {
+		if body != nil {
+			body.Close()
+		}
+		return nil, h.err
+	}
 
 	if body != nil {
 		payload, err := io.ReadAll(body)
@@ -175,46 +237,188 @@ func fromHeaders(headers http.Header, body io.ReadCloser, validators ...wrp.Proc
 	return &msg, nil
 }
 
+// extPrefix marks a header value that is encoded as an RFC 8187 ext-value,
+// because it cannot be sent as is.
+const extPrefix = "utf-8''"
+
+// isAttrChar reports whether c may appear unencoded in an RFC 8187 ext-value.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// isUnsafe reports whether c may not appear in a header value, or is not
+// ASCII.  Tabs are allowed.
+func isUnsafe(c byte) bool {
+	return (c < 0x20 && c != '\t') || c >= 0x7f
+}
+
+// hasExtPrefix reports whether s starts with extPrefix, ignoring case.
+func hasExtPrefix(s string) bool {
+	return len(s) >= len(extPrefix) && strings.EqualFold(s[:len(extPrefix)], extPrefix)
+}
+
+// encodeValue returns s in a form that is safe to use as a header value.
+// Values with control characters, non-ASCII characters or leading or trailing
+// whitespace, and values that could be mistaken for an encoded value, are
+// encoded as an RFC 8187 ext-value with the utf-8 charset and no language,
+// for example:
+//
+//	utf-8''caf%C3%A9
+func encodeValue(field, s string) (string, error) {
+	if !utf8.ValidString(s) {
+		return "", &FieldError{Field: field, Err: errNotUTF8}
+	}
+
+	if isPlain(s) {
+		return s, nil
+	}
+	return extValue(s), nil
+}
+
+// isPlain reports whether s can be sent as a header value as is.
+func isPlain(s string) bool {
+	plain := strings.Trim(s, " \t") == s && !hasExtPrefix(s)
+	for i := 0; plain && i < len(s); i++ {
+		plain = !isUnsafe(s[i])
+	}
+	return plain
+}
+
+// extValue encodes s as an ext-value.
+func extValue(s string) string {
+	var b strings.Builder
+	b.WriteString(extPrefix)
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; isAttrChar(c) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// decodeValue reverses encodeValue.
+func decodeValue(field, s string) (string, error) {
+	if !hasExtPrefix(s) {
+		return s, nil
+	}
+
+	enc := s[len(extPrefix):]
+	var b strings.Builder
+	for i := 0; i < len(enc); i++ {
+		if enc[i] != '%' {
+			b.WriteByte(enc[i])
+			continue
+		}
+		if i+2 >= len(enc) {
+			return "", &FieldError{Field: field, Err: fmt.Errorf("invalid encoding %q", s)}
+		}
+		v, err := strconv.ParseUint(enc[i+1:i+3], 16, 8)
+		if err != nil {
+			return "", &FieldError{Field: field, Err: fmt.Errorf("invalid encoding %q", s)}
+		}
+		b.WriteByte(byte(v))
+		i += 2
+	}
+
+	rv := b.String()
+	if !utf8.ValidString(rv) {
+		return "", &FieldError{Field: field, Err: errNotUTF8}
+	}
+	return rv, nil
+}
+
+// wrpHeader converts fields to and from headers, keeping the first error so
+// the conversion can be checked once at the end.
 type wrpHeader struct {
 	headers http.Header
 	typ     string
+	err     error
+}
+
+func (h *wrpHeader) fail(err error) {
+	if h.err == nil {
+		h.err = err
+	}
 }
 
-func (h wrpHeader) toStringHeader(key hdr, value string, headers http.Header) {
+func (h *wrpHeader) toStringHeader(key hdr, field, value string, headers http.Header) {
 	if value != "" {
-		headers.Set(key.As(h.typ), value)
+		v, err := encodeValue(field, value)
+		if err != nil {
+			h.fail(err)
+			return
+		}
+		headers.Set(key.As(h.typ), v)
 	}
 }
 
-func (h wrpHeader) toIntPtrHeader(key hdr, value *int64, headers http.Header) {
+func (h *wrpHeader) toIntPtrHeader(key hdr, value *int64, headers http.Header) {
 	if value != nil {
 		headers.Set(key.As(h.typ), fmt.Sprintf("%d", *value))
 	}
 }
 
-func (h wrpHeader) readString(key hdr, target *string) {
+// item escapes one item of a list valued header.
+func (h *wrpHeader) item(field, value, reserved string) string {
+	if !utf8.ValidString(value) {
+		h.fail(&FieldError{Field: field, Err: errNotUTF8})
+		return ""
+	}
+	return escapeItem(value, reserved)
+}
+
+// listItem escapes one item of the PartnerIDs or Headers.  Empty items are
+// written as ext-values, since empty items are dropped when the list is split,
+// and so are Headers entries without a colon, which would otherwise be taken
+// as the rest of the previous entry once a proxy combines the lines.
+func (h *wrpHeader) listItem(field, value string, pairs bool) string {
+	if value != "" && (!pairs || strings.Contains(value, ":")) {
+		return h.item(field, value, reservedItem)
+	}
+	if !utf8.ValidString(value) {
+		h.fail(&FieldError{Field: field, Err: errNotUTF8})
+		return ""
+	}
+	return extValue(value)
+}
+
+// unescape reverses item and listItem.
+func (h *wrpHeader) unescape(field, value string) string {
+	rv, err := decodeValue(field, value)
+	if err != nil {
+		h.fail(err)
+	}
+	return rv
+}
+
+func (h *wrpHeader) readString(key hdr, field string, target *string) {
 	if val := key.Get(h.headers); val != "" {
-		*target = val
+		v, err := decodeValue(field, val)
+		if err != nil {
+			h.fail(err)
+			return
+		}
+		*target = v
 	}
 }
 
-func (h wrpHeader) readStrings(key hdr, target *[]string) {
-	if val := key.Values(h.headers); len(val) > 0 {
-		list := make([]string, 0, len(val))
-		for _, p := range val {
-			items := strings.Split(p, ",")
-			for _, item := range items {
-				item = strings.TrimSpace(item)
-				if item != "" {
-					list = append(list, item)
-				}
-			}
+func (h *wrpHeader) readStrings(key hdr, field string, target *[]string) {
+	if items := splitItems(key.Values(h.headers), false); len(items) > 0 {
+		list := make([]string, 0, len(items))
+		for _, item := range items {
+			list = append(list, h.unescape(field, item))
 		}
 		*target = list
 	}
 }
 
-func (h wrpHeader) readInt(key hdr, target **int64) {
+func (h *wrpHeader) readInt(key hdr, target **int64) {
 	if val := key.Get(h.headers); val != "" {
 		v, err := strconv.ParseInt(val, 10, 64)
 		if err == nil {
@@ -226,28 +430,33 @@ func (h wrpHeader) readInt(key hdr, target **int64) {
 	}
 }
 
-func (h wrpHeader) readHashmap(key hdr, target *map[string]string) {
-	if hashmap := key.Values(h.headers); len(hashmap) > 0 {
+func (h *wrpHeader) readQOS(key hdr, target *wrp.QOSValue) {
+	if val := key.Get(h.headers); val != "" {
+		v, err := strconv.Atoi(val)
+		if err == nil {
+			*target = wrp.QOSValue(v)
+		}
+	}
+}
+
+func (h *wrpHeader) readHashmap(key hdr, field string, target *map[string]string) {
+	if items := splitItems(key.Values(h.headers), true); len(items) > 0 {
 		rv := make(map[string]string)
-		for _, m := range hashmap {
-			pair := strings.SplitN(m, ":", 2)
-			if len(pair) == 2 {
-				key := strings.TrimSpace(pair[0])
-				value := strings.TrimSpace(pair[1])
-				rv[key] = value
+		for _, item := range items {
+			k, v, ok := strings.Cut(item, ":")
+			if ok {
+				rv[h.unescape(field, strings.TrimSpace(k))] = h.unescape(field, strings.TrimSpace(v))
 			}
 		}
 		*target = rv
 	}
 }
 
-func (h wrpHeader) readHeaders(key hdr, target *[]string) {
-	if array := key.Values(h.headers); len(array) > 0 {
-		rv := make([]string, 0, len(array))
-		for _, item := range array {
-			if item != "" {
-				rv = append(rv, item)
-			}
+func (h *wrpHeader) readHeaders(key hdr, field string, target *[]string) {
+	if items := splitItems(key.Values(h.headers), true); len(items) > 0 {
+		rv := make([]string, 0, len(items))
+		for _, item := range items {
+			rv = append(rv, h.unescape(field, item))
 		}
 		*target = rv
 	}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func int64Ptr(v int64) *int64 {
+	return &v
+}
+
+// fullMessages use every field of wrp.Message, including values with the
+// characters the header form has to escape.
+var fullMessages = []wrp.Message{
+	{
+		Type:                    wrp.SimpleRequestResponseMessageType,
+		Source:                  "dns:source.example.com/service",
+		Destination:             "mac:112233445566/config",
+		TransactionUUID:         "c5e3a36e-7bfa-4b03-8b4e-0b5d7c9e53a1",
+		ContentType:             "application/json; charset=utf-8",
+		Accept:                  "application/json, text/plain",
+		Status:                  int64Ptr(404),
+		RequestDeliveryResponse: int64Ptr(2),
+		Headers:                 []string{"Accept: a, b", "plain", "X-Percent: 100%", "", "X-Padded:  padded ", "a,b"},
+		Metadata: map[string]string{
+			"/boot-time":           "1542834188",
+			"key:with:colons":      "value:with:colons",
+			"key,with,commas":      "value, with, commas",
+			"/last-reconnect-note": "  padded  ",
+			"/empty":               "",
+			"%2C":                  "%3A",
+		},
+		Path:             "/api/v1/device/config",
+		Payload:          []byte(`{"hello":"world"}`),
+		ServiceName:      "config",
+		URL:              "https://example.com/path?a=1,2",
+		PartnerIDs:       []string{"comcast", "", "partner,with,commas", "50%", "utf-8''x"},
+		SessionID:        "session-1",
+		QualityOfService: wrp.QOSHighValue,
+	}, {
+		Type:             wrp.SimpleEventMessageType,
+		Source:           "mac:112233445566",
+		Destination:      "event:device-status/mac:112233445566/online",
+		TransactionUUID:  "uuid",
+		Headers:          []string{"X: a,b", "plain"},
+		Metadata:         map[string]string{"k": "v"},
+		Payload:          []byte("payload"),
+		PartnerIDs:       []string{"single"},
+		QualityOfService: 99,
+	},
+}
+
+func TestHeadersFormRoundTrip(t *testing.T) {
+	// Every field must be used, so a new field in wrp.Message fails here
+	// until the header form covers it.
+	v := reflect.ValueOf(fullMessages[0])
+	for i := range v.NumField() {
+		assert.False(t, v.Field(i).IsZero(), "field %s is not used", v.Type().Field(i).Name)
+	}
+
+	for _, style := range orderedStyles {
+		for _, msg := range fullMessages {
+			t.Run(style+"/"+msg.Type.FriendlyName(), func(t *testing.T) {
+				headers, payload, err := toHeadersForm(&msg, style, false, wrp.NoStandardValidation())
+				require.NoError(t, err)
+
+				got, err := fromHeaders(headers, io.NopCloser(bytes.NewReader(payload)), wrp.NoStandardValidation())
+				require.NoError(t, err)
+				assert.Equal(t, msg, *got.(*wrp.Message))
+
+				// Proxies may combine repeated headers into one line.
+				combined := make(http.Header, len(headers))
+				for k, v := range headers {
+					combined.Set(k, strings.Join(v, ", "))
+				}
+				got, err = fromHeaders(combined, io.NopCloser(bytes.NewReader(payload)), wrp.NoStandardValidation())
+				require.NoError(t, err)
+				assert.Equal(t, msg, *got.(*wrp.Message))
+			})
+		}
+	}
+}
+
+func TestHeadersFormLegacy(t *testing.T) {
+	headers := http.Header{
+		"X-Xmidt-Message-Type": []string{"SimpleEvent"},
+		"X-Xmidt-Metadata":     []string{"/a:1,2", "/b:100%", "/url:http://a/b%20c"},
+		"X-Xmidt-Headers":      []string{"Accept: a, b", "Referer: http://a/%41"},
+		"X-Xmidt-Partner-Id":   []string{"one, two", "comcast%2Cfoo"},
+	}
+
+	got, err := fromHeaders(headers, nil, wrp.NoStandardValidation())
+	require.NoError(t, err)
+
+	msg := got.(*wrp.Message)
+	assert.Equal(t, map[string]string{"/a": "1,2", "/b": "100%", "/url": "http://a/b%20c"}, msg.Metadata)
+	assert.Equal(t, []string{"Accept: a, b", "Referer: http://a/%41"}, msg.Headers)
+	assert.Equal(t, []string{"one", "two", "comcast%2Cfoo"}, msg.PartnerIDs)
+}
+
+func TestEscapeItem(t *testing.T) {
+	tests := []struct {
+		in       string
+		reserved string
+		expected string
+	}{
+		{"plain", reservedItem, "plain"},
+		{"a,b", reservedItem, "utf-8''a%2Cb"},
+		{"a:b", reservedItem, "a:b"},
+		{"a:b", reservedKey, "utf-8''a%3Ab"},
+		{"100%", reservedItem, "100%"},
+		{"b%20c", reservedItem, "b%20c"},
+		{"100%,", reservedItem, "utf-8''100%25%2C"},
+		{" a b ", reservedItem, "utf-8''%20a%20b%20"},
+		{"\t", reservedItem, "utf-8''%09"},
+		{"utf-8''a", reservedItem, "utf-8''utf-8%27%27a"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.in, func(t *testing.T) {
+			got := escapeItem(test.in, test.reserved)
+			assert.Equal(t, test.expected, got)
+			v, err := decodeValue("", got)
+			require.NoError(t, err)
+			assert.Equal(t, test.in, v)
+		})
+	}
+}
+
+func TestHeadersFormUnsafeValues(t *testing.T) {
+	msg := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:example.com\r\nX-Injected: yes",
+		Destination:     "mac:112233445566/café",
+		TransactionUUID: " padded ",
+		Path:            "/a\nb",
+		ServiceName:     "utf-8''looks-encoded",
+		ContentType:     "text/plain;\tcharset=utf-8",
+		Accept:          "\x7f",
+		Metadata:        map[string]string{"/ключ": "значение\r\n"},
+		Headers:         []string{"X-Name: \r\nX-Injected: yes"},
+		PartnerIDs:      []string{"ü"},
+	}
+
+	headers, payload, err := toHeadersForm(&msg, styleXXmidt, false, wrp.NoStandardValidation())
+	require.NoError(t, err)
+
+	for k, values := range headers {
+		for _, v := range values {
+			for i := 0; i < len(v); i++ {
+				assert.False(t, isUnsafe(v[i]), "header %s has an unsafe value %q", k, v)
+			}
+		}
+	}
+	assert.Empty(t, headers.Get("X-Injected"))
+	assert.Equal(t, "text/plain;\tcharset=utf-8", headers.Get("X-Xmidt-Content-Type"))
+	assert.Equal(t, "utf-8''mac%3A112233445566%2Fcaf%C3%A9", headers.Get("X-Xmidt-Destination"))
+
+	got, err := fromHeaders(headers, io.NopCloser(bytes.NewReader(payload)), wrp.NoStandardValidation())
+	require.NoError(t, err)
+	msg.Payload = []byte{}
+	assert.Equal(t, msg, *got.(*wrp.Message))
+
+	// The values must also survive a real HTTP exchange.
+	req, err := http.NewRequest(http.MethodPost, "http://example.com", nil)
+	require.NoError(t, err)
+	req.Header = headers
+	var buf bytes.Buffer
+	require.NoError(t, req.Write(&buf))
+	assert.NotContains(t, buf.String(), "\r\nX-Injected")
+}
+
+func TestHeadersFormFieldErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   wrp.Message
+		field string
+	}{
+		{"source", wrp.Message{Source: "\xff"}, "Source"},
+		{"path", wrp.Message{Path: "a\xc3"}, "Path"},
+		{"metadata key", wrp.Message{Metadata: map[string]string{"\xff": "v"}}, "Metadata"},
+		{"metadata value", wrp.Message{Metadata: map[string]string{"k": "\xff"}}, "Metadata"},
+		{"headers", wrp.Message{Headers: []string{"\xff"}}, "Headers"},
+		{"partner ids", wrp.Message{PartnerIDs: []string{"\xff"}}, "PartnerIDs"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.msg.Type = wrp.SimpleEventMessageType
+			_, _, err := toHeadersForm(&test.msg, styleXXmidt, false, wrp.NoStandardValidation())
+
+			var fe *FieldError
+			require.ErrorAs(t, err, &fe)
+			assert.Equal(t, test.field, fe.Field)
+			assert.ErrorIs(t, err, ErrInvalidMessage)
+		})
+	}
+
+	decodeTests := []struct {
+		name   string
+		header string
+		value  string
+		field  string
+	}{
+		{"truncated escape", "X-Xmidt-Source", "utf-8''abc%2", "Source"},
+		{"invalid escape", "X-Xmidt-Path", "UTF-8''%zz", "Path"},
+		{"not utf-8", "X-Xmidt-Destination", "utf-8''%FF", "Destination"},
+		{"escaped item not utf-8", "X-Xmidt-Partner-Id", "a,utf-8''%FF", "PartnerIDs"},
+	}
+
+	for _, test := range decodeTests {
+		t.Run(test.name, func(t *testing.T) {
+			headers := http.Header{"X-Xmidt-Message-Type": []string{"SimpleEvent"}}
+			headers.Set(test.header, test.value)
+
+			_, err := fromHeaders(headers, nil, wrp.NoStandardValidation())
+
+			var fe *FieldError
+			require.ErrorAs(t, err, &fe)
+			assert.Equal(t, test.field, fe.Field)
+			assert.ErrorIs(t, err, ErrInvalidMessage)
+		})
+	}
+}
@@ -70,7 +70,7 @@ var (
 func toMediaType(mt, style string) (mediaType, error) {
 	got, ok := mtFromString[mt]
 	if !ok {
-		return mtUnknown, fmt.Errorf("unsupported media type: %s", mt)
+		return mtUnknown, fmt.Errorf("%w: %s", ErrUnsupportedMediaType, mt)
 	}
 
 	if got != mtOctetStream {
@@ -93,15 +93,15 @@ func toMediaType(mt, style string) (mediaType, error) {
 			"%q, %q, %q, %q, %q",
 			"", styleXXmidt, styleXMidt, styleXmidt, styleXWebpa)
 		return mtUnknown, fmt.Errorf(
-			"unsupported octet-stream style: %s, must be one of %s",
-			style, allowed)
+			"%w: octet-stream style %s, must be one of %s",
+			ErrUnsupportedMediaType, style, allowed)
 	}
 }
 
 func toMediaTypeFromMime(s string) (mediaType, error) {
 	mt, params, err := mime.ParseMediaType(strings.TrimSpace(s))
 	if err != nil {
-		return mtUnknown, err
+		return mtUnknown, fmt.Errorf("%w: %w", ErrInvalidContentType, err)
 	}
 
 	return toMediaType(mt, params["style"])
@@ -4,12 +4,13 @@
 package wrphttp
 
 import (
-	"compress/flate"
-	"compress/gzip"
-	"compress/zlib"
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
+	"slices"
 	"strings"
 
 	"github.com/xmidt-org/wrp-go/v5"
@@ -106,14 +107,15 @@ func AsMsgpackL() Option {
 
 // AsNegotiated sets the encoder to use the negotiated media type from the
 // request.  This is useful for ensuring that the encoder is compatible with
-// the negotiated media type from the request.
-func AsNegotiated(r *http.Request) Option {
-	mt, err := negotiatedMediaType(r)
+// the negotiated media type from the request.  The options control the
+// negotiation; see NegotiateMediaType for details.
+func AsNegotiated(r *http.Request, opts ...NegotiateOption) Option {
+	mt, err := negotiatedMediaType(r, opts...)
 	if err != nil {
 		return errOption(err)
 	}
 
-	return asType(mt)
+	return multiOption(asType(mt), varyOn("Accept"))
 }
 
 // AsMediaType sets the encoder to use the specified media type.  The media type
@@ -139,7 +141,7 @@ func asType(mt mediaType) Option {
 			e.style = styleXmidt
 		default:
 			// This should only happen if there is a bug in the code.
-			return fmt.Errorf("invalid media type %q", mt)
+			return fmt.Errorf("%w: %q", ErrUnsupportedMediaType, mt)
 		}
 
 		e.mt = mt
@@ -149,48 +151,212 @@ func asType(mt mediaType) Option {
 }
 
 // EncodeGzip uses the gzip compressor with the specified compression level.
+// If the gzip coding has been replaced with RegisterContentCoding, the
+// replacement is used and the level is ignored.
 func EncodeGzip(level ...int) Option {
-	return optionFunc(func(e *Encoder) {
-		levels := append(level, gzip.DefaultCompression)
-		e.compressor = func(w io.Writer) (io.WriteCloser, error) {
-			return gzip.NewWriterLevel(w, levels[0])
-		}
-		e.encoding = "gzip"
-	})
+	return encodeBuiltin("gzip", gzipCoding, level)
 }
 
 // EncodeDeflate uses the deflate compressor with the specified compression level.
+// If the deflate coding has been replaced with RegisterContentCoding, the
+// replacement is used and the level is ignored.
 func EncodeDeflate(level ...int) Option {
+	return encodeBuiltin("deflate", deflateCoding, level)
+}
+
+// EncodeZlib uses the zlib compressor with the specified compression level.
+// If the zlib coding has been replaced with RegisterContentCoding, the
+// replacement is used and the level is ignored.
+func EncodeZlib(level ...int) Option {
+	return encodeBuiltin("zlib", zlibCoding, level)
+}
+
+// encodeBuiltin uses the registered coding with the provided name, at the
+// requested level while it is still the built in one.
+func encodeBuiltin(name string, builtin func(level int) ContentCoding, level []int) Option {
+	c, err := lookupContentCoding(name)
+	if err != nil {
+		return errOption(err)
+	}
+	if _, ok := c.(builtinCoding); ok && len(level) > 0 {
+		c = builtin(level[0])
+	}
+	return encodeWith(c)
+}
+
+// EncodeCoding uses the registered content coding with the provided name.  See
+// RegisterContentCoding.  An unknown name results in an error matching
+// ErrUnsupportedEncoding.
+func EncodeCoding(name string) Option {
+	c, err := lookupContentCoding(name)
+	if err != nil {
+		return errOption(err)
+	}
+	return encodeWith(c)
+}
+
+// EncodeCodings applies each of the registered content codings with the
+// provided names, in order.  The Content-Encoding header lists the codings in
+// the order they were applied, for example "deflate, gzip".  Identity codings
+// are ignored.  An unknown name results in an error matching
+// ErrUnsupportedEncoding.
+func EncodeCodings(names ...string) Option {
+	list := make([]ContentCoding, 0, len(names))
+	for _, name := range names {
+		c, err := lookupContentCoding(name)
+		if err != nil {
+			return errOption(err)
+		}
+		list = append(list, c)
+	}
+	return encodeWith(newStackedCoding(list...))
+}
+
+// EncodeNegotiated uses the compressor negotiated from the request's
+// Accept-Encoding header.  See NegotiateEncoding for details.  If no coding
+// the encoder supports is acceptable to the client, NewEncoder returns an
+// error matching ErrNotAcceptableEncoding.  The optional offered list restricts and
+// orders the registered codings that may be chosen.
+func EncodeNegotiated(r *http.Request, offered ...string) Option {
+	encoding, err := NegotiateEncoding(r, offered...)
+	if err != nil {
+		return errOption(err)
+	}
+
+	return multiOption(EncodeCoding(encoding), varyOn("Accept-Encoding"))
+}
+
+// CompressEnvelope compresses the whole multipart body instead of each part
+// when more than one part is produced.  The Content-Encoding header is then
+// set on the top level headers and the parts carry none.  Compressing the
+// envelope is usually far more effective for many small messages.  Bodies
+// that are not multipart are unaffected.  The default value is false.
+func CompressEnvelope(enabled ...bool) Option {
 	return optionFunc(func(e *Encoder) {
-		levels := append(level, flate.DefaultCompression)
-		e.compressor = func(w io.Writer) (io.WriteCloser, error) {
-			return flate.NewWriter(w, levels[0])
+		en := append(enabled, true)
+		e.envelope = en[0]
+	})
+}
+
+// Buffered encodes the whole body before it is returned instead of streaming
+// it from a goroutine.  Requests created by the Encoder then have their
+// ContentLength and GetBody set so net/http can replay them on redirects and
+// retries, and the Content-Length header is set by ToParts.  Streaming is the
+// default and is better suited to large batches.
+func Buffered(enabled ...bool) Option {
+	return optionFunc(func(e *Encoder) {
+		en := append(enabled, true)
+		e.buffered = en[0]
+	})
+}
+
+// EagerValidation validates every message before ToParts returns, so an
+// invalid batch fails before any headers are sent instead of failing part way
+// through reading the body.  Without it only octet-stream messages are
+// validated up front.  The HTTPHandler enables it by default.  The default
+// value is false.
+func EagerValidation(enabled ...bool) Option {
+	return optionFunc(func(e *Encoder) {
+		en := append(enabled, true)
+		e.eager = en[0]
+	})
+}
+
+// WithSignature signs the encoded body with an HMAC of the secret, which is
+// sent in the X-Webpa-Signature header.  The algorithm is one of
+// SignatureSHA1, SignatureSHA256 or SignatureSHA512, and defaults to
+// SignatureSHA1 as used by webhook deliveries.  The HMAC covers the body as
+// sent, after any compression.
+//
+// The body must be complete before the header can be set, so ToParts and
+// NewRequest encode the whole body up front, as if Buffered were set.
+// WriteResponse sends the signature as an HTTP trailer unless the Encoder is
+// Buffered, and EncodeTo adds it to the returned headers.
+func WithSignature(secret []byte, algorithm ...string) Option {
+	return optionFuncErr(func(e *Encoder) error {
+		alg := append(algorithm, SignatureSHA1)[0]
+		s, err := newSigner(secret, alg)
+		if err != nil {
+			return err
 		}
-		e.encoding = "deflate"
+		e.signer = s
+		return nil
 	})
 }
 
-// EncodeZlib uses the zlib compressor with the specified compression level.
-func EncodeZlib(level ...int) Option {
+// WithContentDigest sends a Content-Digest of the encoded body, as described
+// by RFC 9530, using each of the algorithms.  The algorithms are
+// DigestSHA256 and DigestSHA512, and default to DigestSHA256.  The digest
+// covers the body as sent, after any compression.
+//
+// Like WithSignature, ToParts and NewRequest encode the whole body up front,
+// WriteResponse sends the digest as an HTTP trailer unless the Encoder is
+// Buffered, and EncodeTo adds it to the returned headers.
+func WithContentDigest(algorithms ...string) Option {
+	return optionFuncErr(func(e *Encoder) error {
+		algs, err := digestAlgorithms(algorithms)
+		if err != nil {
+			return err
+		}
+		e.digests = algs
+		return nil
+	})
+}
+
+// WithPartDigests sends a Content-Digest with each multipart part, using each
+// of the algorithms.  See WithContentDigest.  Each part is encoded in full
+// before it is written, which bounds the memory used by the size of a part.
+func WithPartDigests(algorithms ...string) Option {
+	return optionFuncErr(func(e *Encoder) error {
+		algs, err := digestAlgorithms(algorithms)
+		if err != nil {
+			return err
+		}
+		e.partDigests = algs
+		return nil
+	})
+}
+
+// Canonical makes the encoded output depend only on the messages, so the same
+// messages always produce byte for byte the same body and headers.  Metadata
+// entries are written in key order and PartnerIDs are sorted, and multipart
+// bodies use a boundary derived from a hash of the parts unless one is set
+// with WithBoundary.  Canonical multipart bodies are therefore encoded in full
+// before anything is written.  JSON fields are always written in a fixed
+// order.  This is useful for signatures, caching, deduplication and golden
+// file tests.  The default value is false.
+//
+// The built in content codings are deterministic, but a coding registered
+// with RegisterContentCoding may not be.
+func Canonical(enabled ...bool) Option {
 	return optionFunc(func(e *Encoder) {
-		levels := append(level, zlib.DefaultCompression)
-		e.compressor = func(w io.Writer) (io.WriteCloser, error) {
-			return zlib.NewWriterLevel(w, levels[0])
+		en := append(enabled, true)
+		e.canonical = en[0]
+	})
+}
+
+// WithBoundary sets the boundary of multipart bodies instead of generating a
+// random one.  The boundary must be valid as described by RFC 2046 and must
+// not occur in any of the parts.
+func WithBoundary(boundary string) Option {
+	return optionFuncErr(func(e *Encoder) error {
+		if err := multipart.NewWriter(io.Discard).SetBoundary(boundary); err != nil {
+			return fmt.Errorf("invalid boundary %q: %w", boundary, err)
 		}
-		e.encoding = "zlib"
+		e.boundary = boundary
+		return nil
 	})
 }
 
 // EncodeNoCompression sets the encoder to not use any compression.  This is the
 // default behavior.
 func EncodeNoCompression() Option {
+	return encodeWith(identity())
+}
+
+func encodeWith(c ContentCoding) Option {
 	return optionFunc(func(e *Encoder) {
-		e.compressor = func(w io.Writer) (io.WriteCloser, error) {
-			return nopWriteCloser{
-				Writer: w,
-			}, nil
-		}
-		e.encoding = "identity"
+		e.coding = c
 	})
 }
 
@@ -224,8 +390,291 @@ func WithMaxItemsPerChunk(maxItems int) Option {
 	})
 }
 
+// varyOn records a request header the encoded response depends on, so it can
+// be listed in the Vary header of the response.
+func varyOn(header string) Option {
+	return optionFunc(func(e *Encoder) {
+		if !slices.Contains(e.vary, header) {
+			e.vary = append(e.vary, header)
+		}
+	})
+}
+
+func multiOption(opts ...Option) Option {
+	return optionFuncErr(func(e *Encoder) error {
+		for _, opt := range opts {
+			if err := opt.apply(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 func errOption(err error) Option {
 	return optionFuncErr(func(e *Encoder) error {
 		return err
 	})
 }
+
+type decoderOptionFunc func(*Decoder)
+
+type decoderOptionFuncErr func(*Decoder) error
+
+func (f decoderOptionFuncErr) apply(d *Decoder) error {
+	return f(d)
+}
+
+func (f decoderOptionFunc) apply(d *Decoder) error {
+	f(d)
+	return nil
+}
+
+// DecodeValidators sets the validators used for each decoded message.
+func DecodeValidators(v ...wrp.Processor) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.validators = append(d.validators, v...)
+	})
+}
+
+// WithMaxCompressedBytes limits the number of bytes read from the body before
+// any content encoding is removed.  A value less than 1 means no limit.
+func WithMaxCompressedBytes(n int64) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxCompressed = n
+	})
+}
+
+// WithMaxDecompressedBytes limits the total number of bytes produced after the
+// content encoding of every part is removed.  This protects against
+// compression bombs.  A value less than 1 means no limit.
+func WithMaxDecompressedBytes(n int64) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxDecompressed = n
+	})
+}
+
+// WithMaxMessages limits the number of messages that may be decoded from a
+// single body.  A value less than 1 means no limit.
+func WithMaxMessages(n int) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxMessages = n
+	})
+}
+
+// WithMaxParts limits the number of parts allowed in a multipart body.  A
+// value less than 1 means no limit.
+func WithMaxParts(n int) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxParts = n
+	})
+}
+
+// WithMaxLineLength limits the length of a single JSONL line.  A value less
+// than 1 uses the default of 64KiB.
+func WithMaxLineLength(n int) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxLineLength = n
+	})
+}
+
+// WithMaxPayloadSize limits the size of the payload of each decoded message.
+// A value less than 1 means no limit.
+func WithMaxPayloadSize(n int) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		d.maxPayload = n
+	})
+}
+
+// WithResponseStatus sets the Status of each message decoded from an
+// http.Response that has no Status to the HTTP status code of the response.
+// This allows the outcome of a plain HTTP exchange to travel on as WRP.
+func WithResponseStatus(enabled ...bool) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		en := append(enabled, true)
+		d.responseStatus = en[0]
+	})
+}
+
+// LenientDecoding skips the messages that fail to decode instead of failing
+// the whole body, as long as the failure is confined to a single JSONL line,
+// MsgpackL item or multipart part.  Exceeding any limit other than the one set
+// by WithMaxPayloadSize still ends decoding, as does a failure of the body
+// itself.  The default value is false.
+//
+// The iterators yield a *DecodeError for each skipped message and carry on
+// with the next one.  The other methods return the messages that were decoded
+// along with a *BatchError listing the failures.
+func LenientDecoding(enabled ...bool) DecoderOption {
+	return decoderOptionFunc(func(d *Decoder) {
+		en := append(enabled, true)
+		d.lenient = en[0]
+	})
+}
+
+// WithSignatureSecrets requires every body to carry an X-Webpa-Signature that
+// matches one of the secrets, see WithSignature.  Several secrets can be
+// provided so they can be rotated without rejecting bodies signed with the
+// previous one.  The whole body is read and verified before any of it is
+// decoded, so a limit should also be set with WithMaxCompressedBytes.  For
+// requests and responses the signature may also be sent as a trailer.  A
+// failed check returns an error matching ErrInvalidSignature.
+func WithSignatureSecrets(secrets ...[]byte) DecoderOption {
+	return decoderOptionFuncErr(func(d *Decoder) error {
+		for _, secret := range secrets {
+			if len(secret) == 0 {
+				return fmt.Errorf("%w: the signature secret is empty", ErrInvalidSignature)
+			}
+			d.secrets = append(d.secrets, bytes.Clone(secret))
+		}
+		return nil
+	})
+}
+
+type handlerOptionFunc func(*HTTPHandler) error
+
+func (f handlerOptionFunc) apply(h *HTTPHandler) error {
+	return f(h)
+}
+
+// WithEncoderOptions sets the options used to create the Encoder for each
+// response, for example EncodeValidators or WithMaxItemsPerChunk.  The media
+// type is always negotiated with the client and overrides any media type
+// option provided here.
+func WithEncoderOptions(opts ...Option) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		h.encoderOpts = append(h.encoderOpts, opts...)
+		return nil
+	})
+}
+
+// WithDecoderOptions sets the options used to create the Decoder for the
+// requests, for example DecodeValidators or the decoding limits.
+func WithDecoderOptions(opts ...DecoderOption) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		h.decoderOpts = append(h.decoderOpts, opts...)
+		return nil
+	})
+}
+
+// WithNegotiateOptions sets the options used to negotiate the media type of
+// the response.  See NegotiateMediaType.
+func WithNegotiateOptions(opts ...NegotiateOption) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		h.negotiateOpts = append(h.negotiateOpts, opts...)
+		return nil
+	})
+}
+
+// WithNegotiatedEncoding compresses responses with the content coding
+// negotiated from the Accept-Encoding header of the request.  The optional
+// offered list restricts and orders the registered codings that may be
+// chosen.  See EncodeNegotiated.
+func WithNegotiatedEncoding(offered ...string) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		h.negotiateCodings = true
+		h.offeredCodings = offered
+		return nil
+	})
+}
+
+// WithErrorHandler sets the function used to write the response when
+// decoding, negotiation, the Handler or encoding fails, replacing the default
+// WRP formatted response.  See WriteError and TextErrorHandler.
+func WithErrorHandler(fn ErrorHandlerFunc) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		if fn == nil {
+			return errors.New("error handler is nil")
+		}
+		h.onError = fn
+		return nil
+	})
+}
+
+// WithErrorStatus maps errors matching target to the HTTP status code used
+// for the error response.  Errors are matched using errors.Is.  Mappings are
+// checked in the order they are provided, before the defaults of StatusCode.
+func WithErrorStatus(target error, status int) HandlerOption {
+	return WithErrorStatusFunc(StatusFor(target, status))
+}
+
+// WithErrorStatusFunc adds a function that chooses the HTTP status code used
+// for the error response.  Functions are checked in the order they are
+// provided, before the defaults of StatusCode.
+func WithErrorStatusFunc(fn StatusFunc) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		if fn == nil {
+			return errors.New("status func is nil")
+		}
+		h.statusFuncs = append(h.statusFuncs, fn)
+		return nil
+	})
+}
+
+// WithStatusPolicy chooses the HTTP status code of each response from the
+// Status of the messages returned by the Handler, instead of always using
+// http.StatusOK.  See FirstStatus, HighestStatus and UniformStatus.
+func WithStatusPolicy(policy StatusPolicy) HandlerOption {
+	return handlerOptionFunc(func(h *HTTPHandler) error {
+		if policy == nil {
+			return errors.New("status policy is nil")
+		}
+		h.statusPolicy = policy
+		return nil
+	})
+}
+
+type clientOptionFunc func(*Client) error
+
+func (f clientOptionFunc) apply(c *Client) error {
+	return f(c)
+}
+
+// WithHTTPClient sets the http.Client used to send requests.  The default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) ClientOption {
+	return clientOptionFunc(func(c *Client) error {
+		if client == nil {
+			return errors.New("http client is nil")
+		}
+		c.client = client
+		return nil
+	})
+}
+
+// WithClientEncoderOptions sets the options used to create the Encoder for
+// each request.
+func WithClientEncoderOptions(opts ...Option) ClientOption {
+	return clientOptionFunc(func(c *Client) error {
+		c.encoderOpts = append(c.encoderOpts, opts...)
+		return nil
+	})
+}
+
+// WithClientDecoderOptions sets the options used to create the Decoder for
+// the replies, for example DecodeValidators or the decoding limits.
+func WithClientDecoderOptions(opts ...DecoderOption) ClientOption {
+	return clientOptionFunc(func(c *Client) error {
+		c.decoderOpts = append(c.decoderOpts, opts...)
+		return nil
+	})
+}
+
+// WithAcceptedMediaTypes sets the media types listed in the Accept header of
+// each request, most preferred first.  Each value must be one of the values
+// returned by AllMediaTypes().  By default every media type the decoders
+// understand is accepted.
+func WithAcceptedMediaTypes(mediaTypes ...string) ClientOption {
+	return clientOptionFunc(func(c *Client) error {
+		if len(mediaTypes) == 0 {
+			return fmt.Errorf("%w: no media types provided", ErrUnsupportedMediaType)
+		}
+		for _, s := range mediaTypes {
+			if _, err := toMediaTypeFromMime(s); err != nil {
+				return err
+			}
+		}
+		c.accept = strings.Join(mediaTypes, ", ")
+		return nil
+	})
+}
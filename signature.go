@@ -0,0 +1,123 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// SignatureHeader is the header that carries the HMAC of the body, in the form
+// "<algorithm>=<hex digest>", for example "sha1=5d41402abc4b2a76b9719d911017c592".
+// This is the form used by webhook deliveries.
+const SignatureHeader = "X-Webpa-Signature"
+
+// The HMAC algorithms that can be used with WithSignature.
+const (
+	SignatureSHA1   = "sha1"
+	SignatureSHA256 = "sha256"
+	SignatureSHA512 = "sha512"
+)
+
+var signatureHashes = map[string]func() hash.Hash{
+	SignatureSHA1:   sha1.New,
+	SignatureSHA256: sha256.New,
+	SignatureSHA512: sha512.New,
+}
+
+// signer computes the signature of a body.
+type signer struct {
+	alg    string
+	secret []byte
+}
+
+func newSigner(secret []byte, alg string) (*signer, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("%w: the signature secret is empty", ErrInvalidSignature)
+	}
+	alg = strings.ToLower(alg)
+	if _, ok := signatureHashes[alg]; !ok {
+		return nil, fmt.Errorf("%w: unsupported algorithm %q", ErrInvalidSignature, alg)
+	}
+
+	return &signer{alg: alg, secret: bytes.Clone(secret)}, nil
+}
+
+func (s *signer) newHash() hash.Hash {
+	return hmac.New(signatureHashes[s.alg], s.secret)
+}
+
+// format returns the header value for the sum of h.
+func (s *signer) format(h hash.Hash) string {
+	return s.alg + "=" + hex.EncodeToString(h.Sum(nil))
+}
+
+// verifySignature reports whether value is a valid signature of body for any
+// of the secrets.  The digests are compared in constant time.
+func verifySignature(value string, body []byte, secrets [][]byte) error {
+	if value == "" {
+		return fmt.Errorf("%w: missing %s", ErrInvalidSignature, SignatureHeader)
+	}
+
+	alg, digest, ok := strings.Cut(strings.TrimSpace(value), "=")
+	newHash, known := signatureHashes[strings.ToLower(alg)]
+	if !ok || !known {
+		return fmt.Errorf("%w: unsupported signature %q", ErrInvalidSignature, value)
+	}
+
+	want, err := hex.DecodeString(digest)
+	if err != nil {
+		return fmt.Errorf("%w: malformed signature %q", ErrInvalidSignature, value)
+	}
+
+	match := false
+	for _, secret := range secrets {
+		h := hmac.New(newHash, secret)
+		h.Write(body)
+		// Check every secret so the time taken does not reveal which one
+		// matched.
+		if hmac.Equal(h.Sum(nil), want) {
+			match = true
+		}
+	}
+	if !match {
+		return fmt.Errorf("%w: signature does not match", ErrInvalidSignature)
+	}
+
+	return nil
+}
+
+// verify reads the whole body and checks its signature before any of it is
+// decoded.  The signature is taken from the headers, or from the trailers
+// once the body has been read.
+func (s *decodeState) verify(headers http.Header, trailer func() http.Header, body io.ReadCloser) (io.ReadCloser, error) {
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+
+	value := headers.Get(SignatureHeader)
+	if value == "" && trailer != nil {
+		if t := trailer(); t != nil {
+			value = t.Get(SignatureHeader)
+		}
+	}
+
+	if err := verifySignature(value, data, s.secrets); err != nil {
+		return nil, err
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func hmacHex(h func() hash.Hash, secret, body []byte) string {
+	mac := hmac.New(h, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWithSignature(t *testing.T) {
+	secret := []byte("secret")
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		prefix   string
+		hashFunc func() hash.Hash
+	}{
+		{
+			name:     "default",
+			opts:     []Option{WithSignature(secret)},
+			prefix:   "sha1=",
+			hashFunc: sha1.New,
+		}, {
+			name:     "sha256 over the compressed body",
+			opts:     []Option{WithSignature(secret, SignatureSHA256), EncodeGzip()},
+			prefix:   "sha256=",
+			hashFunc: sha256.New,
+		}, {
+			name:     "multipart",
+			opts:     []Option{AsJSON(), WithSignature(secret, "SHA256")},
+			prefix:   "sha256=",
+			hashFunc: sha256.New,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			encoder, err := NewEncoder(append(test.opts, EncodeValidators(wrp.NoStandardValidation()))...)
+			require.NoError(t, err)
+
+			req, err := encoder.NewRequest(http.MethodPost, "http://example.com", toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+			assert.Positive(t, req.ContentLength)
+			require.NotNil(t, req.GetBody)
+
+			body, err := io.ReadAll(req.Body)
+			require.NoError(t, err)
+			assert.Equal(t, test.prefix+hmacHex(test.hashFunc, secret, body), req.Header.Get(SignatureHeader))
+
+			var buf bytes.Buffer
+			headers, err := encoder.EncodeTo(&buf, toUnion(testWRPMessages)...)
+			require.NoError(t, err)
+			assert.Equal(t, test.prefix+hmacHex(test.hashFunc, secret, buf.Bytes()), headers.Get(SignatureHeader))
+
+			decoder, err := NewDecoder(
+				DecodeValidators(wrp.NoStandardValidation()),
+				WithSignatureSecrets([]byte("old"), secret),
+			)
+			require.NoError(t, err)
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			got, err := decoder.DecodeRequest(req)
+			require.NoError(t, err)
+			assert.Len(t, got, len(testWRPMessages))
+		})
+	}
+
+	t.Run("invalid options", func(t *testing.T) {
+		_, err := NewEncoder(WithSignature(nil))
+		require.ErrorIs(t, err, ErrInvalidSignature)
+
+		_, err = NewEncoder(WithSignature(secret, "md5"))
+		require.ErrorIs(t, err, ErrInvalidSignature)
+
+		_, err = NewDecoder(WithSignatureSecrets(secret, nil))
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	})
+}
+
+func TestWithSignatureSecrets(t *testing.T) {
+	body := []byte(`{"msg_type":4,"source":"mac:112233445566"}`)
+
+	tests := []struct {
+		name      string
+		signature string
+		err       error
+	}{
+		{"current secret", "sha1=" + hmacHex(sha1.New, []byte("new"), body), nil},
+		{"previous secret", "sha256=" + hmacHex(sha256.New, []byte("old"), body), nil},
+		{"wrong secret", "sha1=" + hmacHex(sha1.New, []byte("wrong"), body), ErrInvalidSignature},
+		{"missing", "", ErrInvalidSignature},
+		{"malformed", "sha1=zz", ErrInvalidSignature},
+		{"no algorithm", hmacHex(sha1.New, []byte("new"), body), ErrInvalidSignature},
+		{"unsupported algorithm", "md5=" + hmacHex(sha1.New, []byte("new"), body), ErrInvalidSignature},
+	}
+
+	decoder, err := NewDecoder(
+		DecodeValidators(wrp.NoStandardValidation()),
+		WithSignatureSecrets([]byte("new"), []byte("old")),
+	)
+	require.NoError(t, err)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://example.com", bytes.NewReader(body))
+			req.Header.Set("Content-Type", MEDIA_TYPE_JSON)
+			if test.signature != "" {
+				req.Header.Set(SignatureHeader, test.signature)
+			}
+
+			got, err := decoder.DecodeRequest(req)
+			if test.err != nil {
+				require.ErrorIs(t, err, test.err)
+				assert.Equal(t, http.StatusForbidden, StatusCode(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, got, 1)
+		})
+	}
+}
+
+func TestSignedResponse(t *testing.T) {
+	secret := []byte("secret")
+
+	for _, buffered := range []bool{false, true} {
+		name := "trailer"
+		if buffered {
+			name = "buffered"
+		}
+		t.Run(name, func(t *testing.T) {
+			encoder, err := NewEncoder(
+				AsJSONL(),
+				WithSignature(secret),
+				Buffered(buffered),
+				EncodeValidators(wrp.NoStandardValidation()),
+			)
+			require.NoError(t, err)
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+				assert.NoError(t, encoder.WriteResponse(w, http.StatusOK, toUnion(testWRPMessages)...))
+			}))
+			t.Cleanup(server.Close)
+
+			resp, err := http.Get(server.URL) // nolint: noctx
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			assert.Equal(t, buffered, resp.Header.Get(SignatureHeader) != "")
+
+			decoder, err := NewDecoder(DecodeValidators(wrp.NoStandardValidation()), WithSignatureSecrets(secret))
+			require.NoError(t, err)
+			got, err := decoder.DecodeResponse(resp)
+			require.NoError(t, err)
+			assert.Len(t, got, len(testWRPMessages))
+
+			if !buffered {
+				assert.NotEmpty(t, resp.Trailer.Get(SignatureHeader))
+			}
+		})
+	}
+
+	t.Run("tampered", func(t *testing.T) {
+		encoder, err := NewEncoder(AsJSONL(), WithSignature([]byte("other")), EncodeValidators(wrp.NoStandardValidation()))
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		require.NoError(t, encoder.WriteResponse(rec, http.StatusOK, toUnion(testWRPMessages)...))
+		resp := rec.Result()
+		require.NotEmpty(t, resp.Trailer.Get(SignatureHeader))
+
+		decoder, err := NewDecoder(DecodeValidators(wrp.NoStandardValidation()), WithSignatureSecrets(secret))
+		require.NoError(t, err)
+		_, err = decoder.DecodeResponse(resp)
+		require.ErrorIs(t, err, ErrInvalidSignature)
+	})
+}
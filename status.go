@@ -0,0 +1,195 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+// StatusFunc returns the HTTP status code for err and true, or false if it
+// does not handle err.
+type StatusFunc func(err error) (int, bool)
+
+// StatusFor returns a StatusFunc that maps errors matching target to status.
+// Errors are matched using errors.Is.
+func StatusFor(target error, status int) StatusFunc {
+	return func(err error) (int, bool) {
+		if errors.Is(err, target) {
+			return status, true
+		}
+		return 0, false
+	}
+}
+
+// ErrorStatus returns the HTTP status code for err.  The funcs are consulted
+// in order and the first one that handles err decides, otherwise StatusCode is
+// used.
+func ErrorStatus(err error, funcs ...StatusFunc) int {
+	for _, fn := range funcs {
+		if fn == nil {
+			continue
+		}
+		if status, ok := fn(err); ok {
+			return status
+		}
+	}
+	return StatusCode(err)
+}
+
+// NewErrorMessage returns the SimpleRequestResponse message that reports err
+// with the provided status in reply to req.  The source and destination of req
+// are swapped and its TransactionUUID is echoed.  The error text is the
+// payload.  The req may be nil.
+func NewErrorMessage(req wrp.Union, status int, err error) *wrp.Message {
+	msg := wrp.Message{
+		Type:        wrp.SimpleRequestResponseMessageType,
+		ContentType: "text/plain; charset=utf-8",
+	}
+	msg.SetStatus(int64(status))
+	if err != nil {
+		msg.Payload = []byte(err.Error())
+	}
+
+	var in wrp.Message
+	if !isNil(req) &&
+		req.To(&in, wrp.NoStandardValidation()) == nil {
+		msg.Source = in.Destination
+		msg.Destination = in.Source
+		msg.TransactionUUID = in.TransactionUUID
+	}
+
+	return &msg
+}
+
+// WriteError writes err to w as WRP messages in the media type negotiated
+// from r, using the status code chosen by ErrorStatus.  One error message is
+// written in reply to each of the request messages, or a single one if there
+// are none.  See NewErrorMessage.
+//
+// If no WRP media type is acceptable to the client the error is written as
+// plain text instead.
+func WriteError(w http.ResponseWriter, r *http.Request, err error, msgs []wrp.Union, funcs ...StatusFunc) {
+	writeError(w, r, err, msgs, nil, funcs)
+}
+
+func writeError(w http.ResponseWriter, r *http.Request, err error, msgs []wrp.Union, negotiate []NegotiateOption, funcs []StatusFunc) {
+	status := ErrorStatus(err, funcs...)
+
+	opts := []Option{EncodeValidators(wrp.NoStandardValidation())}
+	if r != nil {
+		mt, nerr := negotiatedMediaType(r, negotiate...)
+		switch {
+		case nerr == nil:
+			opts = append(opts, asType(mt), varyOn("Accept"))
+		case len(r.Header.Values("Accept")) > 0:
+			// The client does not accept any WRP media type.
+			TextErrorHandler(w, r, err)
+			return
+		}
+	}
+
+	encoder, eerr := NewEncoder(opts...)
+	if eerr != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	replies := make([]wrp.Union, 0, max(len(msgs), 1))
+	for _, msg := range msgs {
+		replies = append(replies, NewErrorMessage(msg, status, err))
+	}
+	if len(replies) == 0 {
+		replies = append(replies, NewErrorMessage(nil, status, err))
+	}
+
+	if werr := encoder.WriteResponse(w, status, replies...); werr != nil && !errors.Is(werr, ErrPartialResponse) {
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// StatusPolicy chooses the HTTP status code of a response from the Status of
+// the messages it holds.
+type StatusPolicy func(msgs []wrp.Union) int
+
+// MessageStatus returns the Status of msg and true if it is set to an HTTP
+// status code that a response with a body can be sent with.  Informational
+// statuses, http.StatusNoContent and http.StatusNotModified are not, since the
+// messages of the response would be lost.
+func MessageStatus(msg wrp.Union) (int, bool) {
+	status, ok := messageStatus(msg)
+	if !ok || status < 200 || status == http.StatusNoContent || status == http.StatusNotModified {
+		return 0, false
+	}
+	return status, true
+}
+
+// messageStatus returns the Status of msg and true if it is set to a valid
+// HTTP status code.
+func messageStatus(msg wrp.Union) (int, bool) {
+	if isNil(msg) {
+		return 0, false
+	}
+
+	var m wrp.Message
+	if err := msg.To(&m, wrp.NoStandardValidation()); err != nil || m.Status == nil {
+		return 0, false
+	}
+
+	status := *m.Status
+	if status < 100 || status > 599 {
+		return 0, false
+	}
+	return int(status), true
+}
+
+// FirstStatus is a StatusPolicy that uses the status of the first message
+// with a valid status, or http.StatusOK if there is none.
+func FirstStatus(msgs []wrp.Union) int {
+	for _, msg := range msgs {
+		if status, ok := MessageStatus(msg); ok {
+			return status
+		}
+	}
+	return http.StatusOK
+}
+
+// HighestStatus is a StatusPolicy that uses the highest valid status of the
+// messages, so a single failure is reported for the whole response.  If no
+// message has a valid status, http.StatusOK is used.
+func HighestStatus(msgs []wrp.Union) int {
+	rv := 0
+	for _, msg := range msgs {
+		if status, ok := MessageStatus(msg); ok && status > rv {
+			rv = status
+		}
+	}
+	if rv == 0 {
+		return http.StatusOK
+	}
+	return rv
+}
+
+// UniformStatus is a StatusPolicy that uses the status shared by all of the
+// messages, or http.StatusMultiStatus if they differ.  Messages without a
+// valid status count as http.StatusOK.
+func UniformStatus(msgs []wrp.Union) int {
+	rv := 0
+	for _, msg := range msgs {
+		status, ok := MessageStatus(msg)
+		if !ok {
+			status = http.StatusOK
+		}
+		if rv != 0 && status != rv {
+			return http.StatusMultiStatus
+		}
+		rv = status
+	}
+	if rv == 0 {
+		return http.StatusOK
+	}
+	return rv
+}
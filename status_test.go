@@ -0,0 +1,262 @@
+// SPDX-FileCopyrightText: 2025 Comcast Cable Communications Management, LLC
+// SPDX-License-Identifier: Apache-2.0
+
+package wrphttp
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/xmidt-org/wrp-go/v5"
+)
+
+func TestErrorStatus(t *testing.T) {
+	errCustom := errors.New("custom")
+
+	tests := []struct {
+		name     string
+		err      error
+		funcs    []StatusFunc
+		expected int
+	}{
+		{"default", ErrNotAcceptable, nil, http.StatusNotAcceptable},
+		{"mapped", fmt.Errorf("wrapped: %w", errCustom), []StatusFunc{StatusFor(errCustom, http.StatusConflict)}, http.StatusConflict},
+		{"not mapped", ErrNotAcceptable, []StatusFunc{nil, StatusFor(errCustom, http.StatusConflict)}, http.StatusNotAcceptable},
+		{"first wins", errCustom, []StatusFunc{
+			StatusFor(errCustom, http.StatusConflict),
+			StatusFor(errCustom, http.StatusGone),
+		}, http.StatusConflict},
+		{"override default", &LimitError{Limit: LimitParts, Max: 1}, []StatusFunc{
+			StatusFor(ErrLimitExceeded, http.StatusBadRequest),
+		}, http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ErrorStatus(test.err, test.funcs...))
+		})
+	}
+}
+
+func TestNewErrorMessage(t *testing.T) {
+	req := wrp.Message{
+		Type:            wrp.SimpleRequestResponseMessageType,
+		Source:          "dns:example.com",
+		Destination:     "mac:112233445566/service",
+		TransactionUUID: "uuid",
+	}
+
+	got := NewErrorMessage(&req, http.StatusBadRequest, ErrInvalidMessage)
+	assert.Equal(t, wrp.SimpleRequestResponseMessageType, got.Type)
+	assert.Equal(t, req.Destination, got.Source)
+	assert.Equal(t, req.Source, got.Destination)
+	assert.Equal(t, req.TransactionUUID, got.TransactionUUID)
+	require.NotNil(t, got.Status)
+	assert.Equal(t, int64(http.StatusBadRequest), *got.Status)
+	assert.Equal(t, ErrInvalidMessage.Error(), string(got.Payload))
+
+	var nilMsg *wrp.Message
+	got = NewErrorMessage(nilMsg, http.StatusBadRequest, ErrInvalidMessage)
+	assert.Empty(t, got.TransactionUUID)
+	assert.Empty(t, got.Source)
+}
+
+func TestWriteError(t *testing.T) {
+	msgs := toUnion(testWRPMessages[:2])
+
+	tests := []struct {
+		name   string
+		accept string
+		ct     string
+		msgs   []wrp.Union
+		mt     string
+		text   bool
+	}{
+		{
+			name:   "accepted media type",
+			accept: MEDIA_TYPE_JSONL,
+			msgs:   msgs,
+			mt:     MEDIA_TYPE_JSONL,
+		}, {
+			name: "request media type",
+			ct:   MEDIA_TYPE_JSON,
+			msgs: msgs[:1],
+			mt:   MEDIA_TYPE_JSON,
+		}, {
+			name: "unknown request media type",
+			ct:   "text/plain",
+			mt:   MEDIA_TYPE_MSGPACK,
+		}, {
+			name:   "nothing acceptable",
+			accept: "text/html",
+			text:   true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "http://example.com", nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			if test.ct != "" {
+				req.Header.Set("Content-Type", test.ct)
+			}
+
+			rec := httptest.NewRecorder()
+			WriteError(rec, req, ErrInvalidMessage, test.msgs)
+
+			resp := rec.Result()
+			defer resp.Body.Close()
+			require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			if test.text {
+				assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain")
+				return
+			}
+			assert.Equal(t, test.mt, resp.Header.Get("Content-Type"))
+
+			got, err := DecodeResponse(resp, wrp.NoStandardValidation())
+			require.NoError(t, err)
+			require.Len(t, got, max(len(test.msgs), 1))
+			for i, msg := range got {
+				m := msg.(*wrp.Message)
+				if i < len(test.msgs) {
+					assert.Equal(t, testWRPMessages[i].TransactionUUID, m.TransactionUUID)
+				}
+				require.NotNil(t, m.Status)
+				assert.Equal(t, int64(http.StatusBadRequest), *m.Status)
+				assert.Equal(t, ErrInvalidMessage.Error(), string(m.Payload))
+			}
+		})
+	}
+}
+
+func withStatus(status int64) *wrp.Message {
+	var msg wrp.Message
+	msg.SetStatus(status)
+	return &msg
+}
+
+func TestStatusPolicies(t *testing.T) {
+	tests := []struct {
+		name    string
+		msgs    []wrp.Union
+		first   int
+		highest int
+		uniform int
+	}{
+		{
+			name:    "no messages",
+			first:   http.StatusOK,
+			highest: http.StatusOK,
+			uniform: http.StatusOK,
+		}, {
+			name:    "no status",
+			msgs:    []wrp.Union{&wrp.Message{}},
+			first:   http.StatusOK,
+			highest: http.StatusOK,
+			uniform: http.StatusOK,
+		}, {
+			name:    "single",
+			msgs:    []wrp.Union{withStatus(http.StatusNotFound)},
+			first:   http.StatusNotFound,
+			highest: http.StatusNotFound,
+			uniform: http.StatusNotFound,
+		}, {
+			name:    "same",
+			msgs:    []wrp.Union{withStatus(http.StatusAccepted), withStatus(http.StatusAccepted)},
+			first:   http.StatusAccepted,
+			highest: http.StatusAccepted,
+			uniform: http.StatusAccepted,
+		}, {
+			name:    "mixed",
+			msgs:    []wrp.Union{&wrp.Message{}, withStatus(http.StatusNotFound), withStatus(http.StatusServiceUnavailable)},
+			first:   http.StatusNotFound,
+			highest: http.StatusServiceUnavailable,
+			uniform: http.StatusMultiStatus,
+		}, {
+			name:    "not an HTTP status",
+			msgs:    []wrp.Union{withStatus(0), withStatus(1000), withStatus(http.StatusOK)},
+			first:   http.StatusOK,
+			highest: http.StatusOK,
+			uniform: http.StatusOK,
+		}, {
+			name: "status without a body",
+			msgs: []wrp.Union{
+				withStatus(http.StatusSwitchingProtocols),
+				withStatus(http.StatusNoContent),
+				withStatus(http.StatusNotModified),
+			},
+			first:   http.StatusOK,
+			highest: http.StatusOK,
+			uniform: http.StatusOK,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.first, FirstStatus(test.msgs))
+			assert.Equal(t, test.highest, HighestStatus(test.msgs))
+			assert.Equal(t, test.uniform, UniformStatus(test.msgs))
+		})
+	}
+}
+
+func TestWriteStatusResponse(t *testing.T) {
+	encoder, err := NewEncoder(AsJSONL(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	err = encoder.WriteStatusResponse(rec, nil, &wrp.Message{}, withStatus(http.StatusNotFound))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = httptest.NewRecorder()
+	err = encoder.WriteStatusResponse(rec, UniformStatus, &wrp.Message{}, withStatus(http.StatusNotFound))
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusMultiStatus, rec.Code)
+
+	// A status that cannot carry a body would lose the messages.
+	for _, status := range []int64{http.StatusNoContent, http.StatusSwitchingProtocols} {
+		rec = httptest.NewRecorder()
+		err = encoder.WriteStatusResponse(rec, nil, withStatus(status))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, rec.Code)
+
+		got, err := DecodeResponse(rec.Result(), wrp.NoStandardValidation())
+		require.NoError(t, err)
+		require.Len(t, got, 1)
+		assert.Equal(t, status, *got[0].(*wrp.Message).Status)
+	}
+}
+
+func TestWithResponseStatus(t *testing.T) {
+	encoder, err := NewEncoder(AsJSONL(), EncodeValidators(wrp.NoStandardValidation()))
+	require.NoError(t, err)
+
+	newResponse := func() *http.Response {
+		rec := httptest.NewRecorder()
+		require.NoError(t, encoder.WriteResponse(rec, http.StatusBadGateway, &wrp.Message{}, withStatus(http.StatusNotFound)))
+		return rec.Result()
+	}
+
+	decoder, err := NewDecoder(DecodeValidators(wrp.NoStandardValidation()), WithResponseStatus())
+	require.NoError(t, err)
+	got, err := decoder.DecodeResponse(newResponse())
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Equal(t, int64(http.StatusBadGateway), *got[0].(*wrp.Message).Status)
+	assert.Equal(t, int64(http.StatusNotFound), *got[1].(*wrp.Message).Status)
+
+	decoder, err = NewDecoder(DecodeValidators(wrp.NoStandardValidation()), WithResponseStatus(false))
+	require.NoError(t, err)
+	got, err = decoder.DecodeResponse(newResponse())
+	require.NoError(t, err)
+	require.Len(t, got, 2)
+	assert.Nil(t, got[0].(*wrp.Message).Status)
+}